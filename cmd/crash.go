@@ -0,0 +1,179 @@
+/*
+Copyright © 2023 Ben Orgil
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// Crash modes that aren't a signal
+const (
+	crashPanic         = "panic"
+	crashStackOverflow = "stack_overflow"
+)
+
+/*
+--exitcode always makes et exit normally. A supervisor tells a process that
+exited apart from one that died, so --crash makes et die:
+
+	et --stdout='line __I__' --repeat_forever --crash=SIGSEGV --crash_after_lines=10
+
+The modes are:
+
+  - a signal, ie SIGKILL, SIGSEGV or SIGABRT: et kills itself with it. The
+    parent's WaitStatus has Signaled() true and a shell reports 128+n. The
+    core file size limit is raised to its hard limit first so the signals
+    that dump core do when the system allows it
+  - panic: an unrecovered Go panic with its stack trace on stderr, exit code 2
+  - stack_overflow: unbounded recursion until Go's goroutine stack limit is
+    hit, a fatal error with exit code 2
+
+Set GOTRACEBACK=crash to make Go end a panic or a stack overflow with
+SIGABRT instead of exit code 2.
+
+The crash happens after --crash_after or once the streams have written
+--crash_after_lines lines, whichever comes first. If the output is done
+before that et waits out crash_after, or crashes in place of exiting.
+*/
+type crasher struct {
+	mode       string
+	sig        syscall.Signal
+	after      time.Duration
+	afterLines int64
+	started    time.Time
+	lines      atomic.Int64
+	once       sync.Once
+	logger     OutputFormatter
+}
+
+// Returns nil if mode is empty
+func newCrasher(mode string, after time.Duration, afterLines int, logger OutputFormatter) (*crasher, error) {
+	if mode == "" {
+		return nil, nil
+	}
+	c := &crasher{mode: strings.ToLower(mode), after: after, afterLines: int64(afterLines), logger: logger}
+	if c.mode != crashPanic && c.mode != crashStackOverflow {
+		sig, err := lookupCrashSignal(mode)
+		if err != nil {
+			return nil, fmt.Errorf("'crash' must be %v, %v or a signal: %v", crashPanic, crashStackOverflow, err.Error())
+		}
+		c.mode, c.sig = crashSignalName(sig), sig
+	}
+	if after < 0 || afterLines < 0 {
+		return nil, fmt.Errorf("crash_after and crash_after_lines can't be negative")
+	}
+	return c, nil
+}
+
+func lookupCrashSignal(name string) (syscall.Signal, error) {
+	n := strings.ToUpper(strings.TrimSpace(name))
+	if !strings.HasPrefix(n, "SIG") {
+		n = "SIG" + n
+	}
+	if s, ok := crashSignals[n]; ok {
+		return s, nil
+	}
+	s, err := lookupSignal(name)
+	if err != nil {
+		return 0, err
+	}
+	return s.(syscall.Signal), nil
+}
+
+// Like signalName() for the signals et can only be killed by too
+func crashSignalName(sig syscall.Signal) string {
+	for name, s := range crashSignals {
+		if s == sig {
+			return name
+		}
+	}
+	return signalName(sig)
+}
+
+// Start the crash_after timer. Does nothing on a nil crasher
+func (c *crasher) start() {
+	if c == nil {
+		return
+	}
+	c.started = time.Now()
+	if c.after == 0 {
+		return
+	}
+	time.AfterFunc(c.after, func() {
+		c.crash(fmt.Sprintf("after '%v'", c.after))
+	})
+}
+
+// Count a line written by a stream. Does nothing on a nil crasher
+func (c *crasher) line() {
+	if c == nil || c.afterLines == 0 {
+		return
+	}
+	if n := c.lines.Add(1); n == c.afterLines {
+		c.crash(fmt.Sprintf("after '%v' lines", n))
+	}
+}
+
+// Called when the output is done. Does nothing on a nil crasher
+func (c *crasher) exit() {
+	if c == nil {
+		return
+	}
+	if c.after > 0 {
+		c.logger.Logger.Info(fmt.Sprintf("Output done. Waiting for the crash after '%v'", c.after))
+		time.Sleep(time.Until(c.started.Add(c.after)))
+		c.crash(fmt.Sprintf("after '%v'", c.after))
+	}
+	c.crash("instead of exiting")
+}
+
+// Crash. Only the first call does anything and it doesn't return
+func (c *crasher) crash(reason string) {
+	c.once.Do(func() {
+		c.logger.Logger.Warn(fmt.Sprintf("Crashing with '%v' %v", c.mode, reason))
+		switch c.mode {
+		case crashPanic:
+			panic(fmt.Sprintf("et crashed %v", reason))
+		case crashStackOverflow:
+			// Hit the limit in a fraction of a second instead of growing to 1GB
+			debug.SetMaxStack(64 << 20)
+			overflowStack(0)
+		default:
+			if err := killSelf(c.sig); err != nil {
+				c.logger.Logger.Error(fmt.Sprintf("Failed to crash with '%v': %v", c.mode, err.Error()))
+				os.Exit(killedExitCode(c.sig))
+			}
+		}
+	})
+	// Another goroutine is crashing et
+	select {}
+}
+
+// Recurse until the goroutine's stack is exhausted. The frame is kept big and
+// live so the compiler can't shrink it or turn the recursion into a loop
+func overflowStack(depth int) int {
+	var frame [256]byte
+	frame[depth%len(frame)] = byte(depth)
+	return overflowStack(depth+1) + int(frame[(depth+1)%len(frame)])
+}
@@ -0,0 +1,34 @@
+/*
+Copyright © 2023 Ben Orgil
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// Set sig's handler to SIG_DFL behind Go's runtime. A zeroed struct
+// sigaction is SIG_DFL with no flags and an empty mask whatever the
+// architecture's layout is
+func defaultSignalHandler(sig syscall.Signal) error {
+	var act [64]byte
+	_, _, errno := syscall.RawSyscall6(syscall.SYS_RT_SIGACTION, uintptr(sig), uintptr(unsafe.Pointer(&act)), 0, 8, 0, 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+//go:build unix
+
+/*
+Copyright © 2023 Ben Orgil
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/benorgil/exectester/configs"
+)
+
+func (ts *ExecTestSuite) TestCrashSignal() {
+	testArgExePath, present := os.LookupEnv(configs.TestArgExePath)
+	ts.Require().True(present, exe_err)
+
+	for _, sig := range []syscall.Signal{syscall.SIGKILL, syscall.SIGSEGV, syscall.SIGABRT, syscall.SIGTERM} {
+		var stdout bytes.Buffer
+		c := exec.Command(testArgExePath, "--stdout=line", "--repeat_forever", "--repeat_interval=10ms",
+			"--crash="+crashSignalName(sig), "--crash_after_lines=3", "--output_format=raw")
+		// Any core dump is written to the working directory
+		c.Dir, c.Stdout = ts.T().TempDir(), &stdout
+		c.Run()
+
+		ws := c.ProcessState.Sys().(syscall.WaitStatus)
+		ts.True(ws.Signaled(), sig)
+		ts.Equal(sig, ws.Signal())
+		ts.Equal("line\nline\nline\n", stdout.String())
+	}
+
+	// The lines of a scenario count too
+	f := filepath.Join(ts.T().TempDir(), "exectester.yaml")
+	ts.Require().NoError(os.WriteFile(f, []byte("scenario: [{action: emit, stream: stdout, text: line, repeat: 10, interval: 10ms}]\n"), 0644))
+	var stdout bytes.Buffer
+	c := exec.Command(testArgExePath, "--config="+f, "--crash=SIGKILL", "--crash_after_lines=3", "--output_format=raw")
+	c.Stdout = &stdout
+	c.Run()
+	ts.Equal(syscall.SIGKILL, c.ProcessState.Sys().(syscall.WaitStatus).Signal())
+	ts.True(strings.HasSuffix(stdout.String(), "line\nline\nline\n"), stdout.String())
+	ts.Equal(3, strings.Count(stdout.String(), "line\n"))
+}
+
+func (ts *ExecTestSuite) TestCrashGo() {
+	testArgExePath, present := os.LookupEnv(configs.TestArgExePath)
+	ts.Require().True(present, exe_err)
+
+	for mode, want := range map[string]string{
+		crashPanic:         "panic: et crashed instead of exiting",
+		crashStackOverflow: "goroutine stack exceeds",
+	} {
+		var stderr bytes.Buffer
+		c := exec.Command(testArgExePath, "--stdout=o", "--crash="+mode)
+		c.Stderr = &stderr
+		c.Run()
+		ts.Equal(2, c.ProcessState.ExitCode(), mode)
+		ts.Contains(stderr.String(), want)
+	}
+}
+
+func (ts *ExecTestSuite) TestCrashAfter() {
+	testArgExePath, present := os.LookupEnv(configs.TestArgExePath)
+	ts.Require().True(present, exe_err)
+
+	// Waits for the crash after the output is done
+	c := exec.Command(testArgExePath, "--stdout=o", "--crash=SIGKILL", "--crash_after=500ms")
+	start := time.Now()
+	c.Run()
+	ts.GreaterOrEqual(time.Since(start), 500*time.Millisecond)
+	ts.Equal(syscall.SIGKILL, c.ProcessState.Sys().(syscall.WaitStatus).Signal())
+
+	// Or crashes before it is
+	c = exec.Command(testArgExePath, "--stdout=o", "--repeat_forever", "--crash=SIGKILL", "--crash_after=300ms")
+	start = time.Now()
+	c.Run()
+	ts.Less(time.Since(start), 2*time.Second)
+	ts.Equal(syscall.SIGKILL, c.ProcessState.Sys().(syscall.WaitStatus).Signal())
+}
+
+func (ts *ExecTestSuite) TestCrashValidation() {
+	for _, tc := range []struct {
+		name string
+		args []string
+	}{
+		{"unknown signal", []string{"--stdout=o", "--crash=SIGWHAT"}},
+		{"unknown crash mode", []string{"--stdout=o", "--crash=explode"}},
+		{"negative crash_after", []string{"--stdout=o", "--crash=panic", "--crash_after=-1s"}},
+		{"negative crash_after_lines", []string{"--stdout=o", "--crash=panic", "--crash_after_lines=-1"}},
+	} {
+		ts.Run(tc.name, func() {
+			_, err := ts.ExecuteCmd(tc.args)
+			ts.ErrorAs(err, new(*paramSetValidationError), tc.args)
+		})
+	}
+}
@@ -0,0 +1,66 @@
+//go:build unix
+
+/*
+Copyright © 2023 Ben Orgil
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"runtime/debug"
+	"syscall"
+	"time"
+)
+
+// Signals et can only be killed by, on top of the ones it catches
+var crashSignals = map[string]syscall.Signal{
+	"SIGKILL": syscall.SIGKILL,
+	"SIGSEGV": syscall.SIGSEGV,
+	"SIGABRT": syscall.SIGABRT,
+	"SIGBUS":  syscall.SIGBUS,
+	"SIGFPE":  syscall.SIGFPE,
+	"SIGILL":  syscall.SIGILL,
+	"SIGTRAP": syscall.SIGTRAP,
+	"SIGSYS":  syscall.SIGSYS,
+}
+
+// Kill et with sig so it dies the way it would without Go's runtime
+// handling the signal. Only returns if et is still alive
+func killSelf(sig syscall.Signal) error {
+	var core syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_CORE, &core); err == nil && core.Cur < core.Max {
+		core.Cur = core.Max
+		syscall.Setrlimit(syscall.RLIMIT_CORE, &core)
+	}
+
+	// Go's runtime turns SIGSEGV and the like into a goroutine dump and exit
+	// code 2, and ignores SIGPIPE, so its handler is replaced by the default
+	signal.Reset(sig)
+	if sig != syscall.SIGKILL {
+		if err := defaultSignalHandler(sig); err != nil {
+			// The runtime still handles it. With a crash traceback it ends
+			// in SIGABRT at least
+			debug.SetTraceback("crash")
+		}
+	}
+	if err := syscall.Kill(os.Getpid(), sig); err != nil {
+		return err
+	}
+	time.Sleep(5 * time.Second)
+	return fmt.Errorf("still running after sending '%v' to itself", crashSignalName(sig))
+}
@@ -21,6 +21,8 @@ import (
 	"reflect"
 	"slices"
 	"strings"
+
+	"github.com/spf13/viper"
 )
 
 /*
@@ -39,6 +41,12 @@ type outputFormatterEnum string
 const (
 	outputFormatterEnumHuman      outputFormatterEnum = "human_readable"
 	outputFormatterEnumStructured outputFormatterEnum = "structured"
+	outputFormatterEnumRaw        outputFormatterEnum = "raw"
+	outputFormatterEnumLogfmt     outputFormatterEnum = "logfmt"
+	outputFormatterEnumEcs        outputFormatterEnum = "ecs"
+	outputFormatterEnumGelf       outputFormatterEnum = "gelf"
+	outputFormatterEnumOtel       outputFormatterEnum = "otel"
+	outputFormatterEnumTemplate   outputFormatterEnum = "template"
 )
 
 // Used by FlagSet.VarP() method
@@ -67,7 +75,8 @@ func (e *outputFormatterEnum) Type() string {
 // Defining flags error message and redefining allowed values as slice
 // to be able to loop over them dynamically
 var (
-	outputFormatterEnumValues        = []string{"human_readable", "structured"}
+	outputFormatterEnumValues = []string{"human_readable", "structured", "raw",
+		"logfmt", "ecs", "gelf", "otel", "template"}
 	outputFormatterEnumValuesStr     = strings.Join(outputFormatterEnumValues, ", ")
 	outputFormatterEnumValuesInfoMsg = fmt.Sprintf(
 		"The output format to console. Allowed: '%v'", outputFormatterEnumValuesStr)
@@ -76,12 +85,15 @@ var (
 )
 
 // This func if called to process the custom type flags value before returning it
-// This flag returns a custom type that includes the loggers to use for console output
-func outputFormatterEnumHookFunc(f reflect.Type, t reflect.Type, flagValue interface{}) (interface{}, error) {
-	if _, ok := flagValue.(string); ok {
-		of := new(OutputFormatter)
-		return of.getLogger(flagValue.(string)), nil
-	} else {
-		return flagValue, fmt.Errorf("format_output value of '%v' is not a string", flagValue)
+// This flag returns a custom type that includes the loggers to use for console output.
+// The loggers are set up from the command's own viper
+func outputFormatterEnumHookFunc(v *viper.Viper) func(reflect.Type, reflect.Type, interface{}) (interface{}, error) {
+	return func(f reflect.Type, t reflect.Type, flagValue interface{}) (interface{}, error) {
+		if _, ok := flagValue.(string); ok {
+			of := new(OutputFormatter)
+			return of.getLogger(v, flagValue.(string)), nil
+		} else {
+			return flagValue, fmt.Errorf("format_output value of '%v' is not a string", flagValue)
+		}
 	}
 }
@@ -35,12 +35,16 @@ type interpolatorEnum string
 const (
 	interpolatorEnumIntCounter interpolatorEnum = "int_counter"
 	interpolatorEnumString     interpolatorEnum = "string"
+	interpolatorEnumTemplate   interpolatorEnum = "template"
+	interpolatorEnumTimestamp  interpolatorEnum = "timestamp"
+	interpolatorEnumPreset     interpolatorEnum = "preset"
+	interpolatorEnumDataSet    interpolatorEnum = "data_set"
 )
 
 // Defining flags error message and redefining allowed values as slice
 // to be able to loop over them dynamically
 var (
-	interpolatorEnumValues        = []string{"int_counter", "string"}
+	interpolatorEnumValues        = []string{"int_counter", "string", "template", "timestamp", "preset", "data_set"}
 	interpolatorEnumValuesStr     = strings.Join(interpolatorEnumValues, ", ")
 	interpolatorEnumValuesInfoMsg = fmt.Sprintf(
 		"The interpolator to use on the interpolate_key. Allowed: '%v'", interpolatorEnumValuesStr)
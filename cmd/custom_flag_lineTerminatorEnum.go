@@ -0,0 +1,81 @@
+/*
+Copyright © 2023 Ben Orgil
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+/*
+This Cobra flag is just a string
+
+The "lineTerminatorEnum" defined here behaves like an enum. If the user enters a
+value for the flag not defined in the enum they immediately get back a good error.
+*/
+type lineTerminatorEnum string
+
+// An enum of allowed values for this flag
+const (
+	lineTerminatorEnumLf   lineTerminatorEnum = "lf"
+	lineTerminatorEnumCrlf lineTerminatorEnum = "crlf"
+	lineTerminatorEnumNul  lineTerminatorEnum = "nul"
+	lineTerminatorEnumNone lineTerminatorEnum = "none"
+)
+
+// What each value writes after a line
+var lineTerminators = map[lineTerminatorEnum]string{
+	lineTerminatorEnumLf:   "\n",
+	lineTerminatorEnumCrlf: "\r\n",
+	lineTerminatorEnumNul:  "\x00",
+	lineTerminatorEnumNone: "",
+}
+
+// Defining flags error message and redefining allowed values as slice
+// to be able to loop over them dynamically
+var (
+	lineTerminatorEnumValues        = []string{"lf", "crlf", "nul", "none"}
+	lineTerminatorEnumValuesStr     = strings.Join(lineTerminatorEnumValues, ", ")
+	lineTerminatorEnumValuesInfoMsg = fmt.Sprintf(
+		"What output_format=raw writes after each line. Allowed: '%v'", lineTerminatorEnumValuesStr)
+	lineTerminatorEnumValuesErrMsg = fmt.Sprintf(
+		"must be one of: '%v'", lineTerminatorEnumValuesStr)
+)
+
+// Used by FlagSet.VarP() method
+// It's used both by fmt.Print and by Cobra in help text
+func (e *lineTerminatorEnum) String() string {
+	return string(*e)
+}
+
+// Used by FlagSet.VarP() method
+// Needs to have pointer receiver so it doesn't change the value of a copy
+func (e *lineTerminatorEnum) Set(v string) error {
+	if slices.Contains(lineTerminatorEnumValues, v) {
+		*e = lineTerminatorEnum(v)
+		return nil
+	} else {
+		return fmt.Errorf(lineTerminatorEnumValuesErrMsg)
+	}
+}
+
+// Used by FlagSet.VarP() method
+// Only used in help text
+func (e *lineTerminatorEnum) Type() string {
+	return "lineTerminatorEnum"
+}
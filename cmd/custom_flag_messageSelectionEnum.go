@@ -0,0 +1,72 @@
+/*
+Copyright © 2023 Ben Orgil
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+/*
+This Cobra flag is just a string
+
+The "messageSelectionEnum" defined here behaves like an enum. If the user enters a
+value for the flag not defined in the enum they immediately get back a good error.
+*/
+type messageSelectionEnum string
+
+// An enum of allowed values for this flag
+const (
+	messageSelectionEnumRoundRobin messageSelectionEnum = "round_robin"
+	messageSelectionEnumRandom     messageSelectionEnum = "random"
+	messageSelectionEnumWeighted   messageSelectionEnum = "weighted"
+)
+
+// Defining flags error message and redefining allowed values as slice
+// to be able to loop over them dynamically
+var (
+	messageSelectionEnumValues        = []string{"round_robin", "random", "weighted"}
+	messageSelectionEnumValuesStr     = strings.Join(messageSelectionEnumValues, ", ")
+	messageSelectionEnumValuesInfoMsg = fmt.Sprintf(
+		"How a message is picked from the messages for each line. Allowed: '%v'", messageSelectionEnumValuesStr)
+	messageSelectionEnumValuesErrMsg = fmt.Sprintf(
+		"must be one of: '%v'", messageSelectionEnumValuesStr)
+)
+
+// Used by FlagSet.VarP() method
+// It's used both by fmt.Print and by Cobra in help text
+func (e *messageSelectionEnum) String() string {
+	return string(*e)
+}
+
+// Used by FlagSet.VarP() method
+// Needs to have pointer receiver so it doesn't change the value of a copy
+func (e *messageSelectionEnum) Set(v string) error {
+	if slices.Contains(messageSelectionEnumValues, v) {
+		*e = messageSelectionEnum(v)
+		return nil
+	} else {
+		return fmt.Errorf(messageSelectionEnumValuesErrMsg)
+	}
+}
+
+// Used by FlagSet.VarP() method
+// Only used in help text
+func (e *messageSelectionEnum) Type() string {
+	return "messageSelectionEnum"
+}
@@ -0,0 +1,77 @@
+/*
+Copyright © 2023 Ben Orgil
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+/*
+This Cobra flag is just a string
+
+The "rateModeEnum" defined here behaves like an enum. If the user enters a
+value for the flag not defined in the enum they immediately get back a good error.
+*/
+type rateModeEnum string
+
+// An enum of allowed values for this flag
+const (
+	rateModeEnumInterval    rateModeEnum = "interval"
+	rateModeEnumLinesPerSec rateModeEnum = "lines_per_sec"
+	rateModeEnumBytesPerSec rateModeEnum = "bytes_per_sec"
+	rateModeEnumBurst       rateModeEnum = "burst"
+	rateModeEnumUniform     rateModeEnum = "uniform"
+	rateModeEnumPoisson     rateModeEnum = "poisson"
+	rateModeEnumExponential rateModeEnum = "exponential"
+)
+
+// Defining flags error message and redefining allowed values as slice
+// to be able to loop over them dynamically
+var (
+	rateModeEnumValues = []string{"interval", "lines_per_sec", "bytes_per_sec",
+		"burst", "uniform", "poisson", "exponential"}
+	rateModeEnumValuesStr     = strings.Join(rateModeEnumValues, ", ")
+	rateModeEnumValuesInfoMsg = fmt.Sprintf(
+		"How output is paced. Allowed: '%v'", rateModeEnumValuesStr)
+	rateModeEnumValuesErrMsg = fmt.Sprintf(
+		"must be one of: '%v'", rateModeEnumValuesStr)
+)
+
+// Used by FlagSet.VarP() method
+// It's used both by fmt.Print and by Cobra in help text
+func (e *rateModeEnum) String() string {
+	return string(*e)
+}
+
+// Used by FlagSet.VarP() method
+// Needs to have pointer receiver so it doesn't change the value of a copy
+func (e *rateModeEnum) Set(v string) error {
+	if slices.Contains(rateModeEnumValues, v) {
+		*e = rateModeEnum(v)
+		return nil
+	} else {
+		return fmt.Errorf(rateModeEnumValuesErrMsg)
+	}
+}
+
+// Used by FlagSet.VarP() method
+// Only used in help text
+func (e *rateModeEnum) Type() string {
+	return "rateModeEnum"
+}
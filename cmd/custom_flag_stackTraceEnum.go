@@ -0,0 +1,74 @@
+/*
+Copyright © 2023 Ben Orgil
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+/*
+This Cobra flag is just a string
+
+The "stackTraceEnum" defined here behaves like an enum. If the user enters a
+value for the flag not defined in the enum they immediately get back a good error.
+*/
+type stackTraceEnum string
+
+// An enum of allowed values for this flag
+const (
+	stackTraceEnumNone   stackTraceEnum = "none"
+	stackTraceEnumGo     stackTraceEnum = "go"
+	stackTraceEnumJava   stackTraceEnum = "java"
+	stackTraceEnumPython stackTraceEnum = "python"
+	stackTraceEnumNode   stackTraceEnum = "node"
+)
+
+// Defining flags error message and redefining allowed values as slice
+// to be able to loop over them dynamically
+var (
+	stackTraceEnumValues        = []string{"none", "go", "java", "python", "node"}
+	stackTraceEnumValuesStr     = strings.Join(stackTraceEnumValues, ", ")
+	stackTraceEnumValuesInfoMsg = fmt.Sprintf(
+		"Add stack traces in the style of this language to the output. Allowed: '%v'", stackTraceEnumValuesStr)
+	stackTraceEnumValuesErrMsg = fmt.Sprintf(
+		"must be one of: '%v'", stackTraceEnumValuesStr)
+)
+
+// Used by FlagSet.VarP() method
+// It's used both by fmt.Print and by Cobra in help text
+func (e *stackTraceEnum) String() string {
+	return string(*e)
+}
+
+// Used by FlagSet.VarP() method
+// Needs to have pointer receiver so it doesn't change the value of a copy
+func (e *stackTraceEnum) Set(v string) error {
+	if slices.Contains(stackTraceEnumValues, v) {
+		*e = stackTraceEnum(v)
+		return nil
+	} else {
+		return fmt.Errorf(stackTraceEnumValuesErrMsg)
+	}
+}
+
+// Used by FlagSet.VarP() method
+// Only used in help text
+func (e *stackTraceEnum) Type() string {
+	return "stackTraceEnum"
+}
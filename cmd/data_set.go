@@ -0,0 +1,138 @@
+/*
+Copyright © 2023 Ben Orgil
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+/*
+A data set is a CSV file with a header row or a JSON lines file. Every line
+a stream writes takes the next row, starting over after the last one, and
+the row's columns are interpolated with the "data_set" interpolator:
+
+	et --stdout='__IP__ GET __PATH__ __STATUS__' --repeat=1000 --data_set=samples.csv \
+		--interpolate=__IP__=data_set:client_ip \
+		--interpolate=__PATH__=data_set:path \
+		--interpolate=__STATUS__=data_set:status
+
+The row is also available to templates as .Row, ie '{{.Row.path}}'. Values
+of JSON lines that aren't strings are written as JSON and a column missing
+from a row is empty.
+*/
+type dataSetRow map[string]string
+
+// Read a data set. The extension decides if it is CSV or JSON lines
+func loadDataSet(file string) ([]dataSetRow, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	switch ext := strings.ToLower(filepath.Ext(file)); ext {
+	case ".csv":
+		return parseCSVDataSet(b)
+	case ".jsonl", ".ndjson", ".json":
+		return parseJSONLDataSet(b)
+	default:
+		return nil, fmt.Errorf("'%v' must end in .csv, .jsonl, .ndjson or .json", file)
+	}
+}
+
+func parseCSVDataSet(b []byte) ([]dataSetRow, error) {
+	records, err := csv.NewReader(bytes.NewReader(b)).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+
+	header := records[0]
+	rows := make([]dataSetRow, 0, len(records)-1)
+	for _, record := range records[1:] {
+		row := dataSetRow{}
+		for i, v := range record {
+			row[header[i]] = v
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+func parseJSONLDataSet(b []byte) ([]dataSetRow, error) {
+	var rows []dataSetRow
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	scanner.Buffer(nil, 1024*1024)
+	for n := 1; scanner.Scan(); n++ {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		d := json.NewDecoder(bytes.NewReader(line))
+		d.UseNumber()
+		m := map[string]any{}
+		if err := d.Decode(&m); err != nil {
+			return nil, fmt.Errorf("line %v: %v", n, err.Error())
+		}
+
+		row := dataSetRow{}
+		for k, v := range m {
+			if s, ok := v.(string); ok {
+				row[k] = s
+				continue
+			}
+			j, _ := json.Marshal(v)
+			row[k] = string(j)
+		}
+		rows = append(rows, row)
+	}
+	return rows, scanner.Err()
+}
+
+// The data set row of a line. Rows start over after the last one
+func (s streamArgs) row(counter int) dataSetRow {
+	if len(s.dataSet) == 0 {
+		return nil
+	}
+	return s.dataSet[counter%len(s.dataSet)]
+}
+
+// Make sure every column the data_set interpolator asks for is in the data set
+func checkDataSetColumns(s streamArgs) error {
+	for _, i := range s.interpolations {
+		if i.interpolator != string(interpolatorEnumDataSet) {
+			continue
+		}
+		if len(s.dataSet) == 0 {
+			return fmt.Errorf("interpolator 'data_set' needs a 'data_set' file with at least one row")
+		}
+		if !slices.ContainsFunc(s.dataSet, func(r dataSetRow) bool { _, ok := r[i.val]; return ok }) {
+			return fmt.Errorf("data_set has no column '%v'", i.val)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+/*
+Copyright © 2023 Ben Orgil
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+)
+
+func (ts *ExecTestSuite) TestDataSetCSV() {
+	file := filepath.Join(ts.T().TempDir(), "samples.csv")
+	ts.Require().NoError(os.WriteFile(file, []byte("ip,path\n10.0.0.1,/a\n10.0.0.2,\"/b,c\"\n"), 0644))
+
+	cmd, err := ts.ExecuteCmd([]string{"--stdout=__IP__ __P__", "--interpolate=__IP__=data_set:ip", "--interpolate=__P__=data_set:path",
+		"--data_set=" + file, "--repeat=3", "--repeat_interval=0", "--output_format=raw"})
+	ts.Require().NoError(err)
+	ts.Equal("10.0.0.1 /a\n10.0.0.2 /b,c\n10.0.0.1 /a\n", cmd.RawStdOut)
+
+	// Rows are also in templates and fields
+	cmd, err = ts.ExecuteCmd([]string{"--stderr={{.Row.path}}", "--interpolator=template", "--field=ip={{.Row.ip}}",
+		"--data_set=" + file, "--repeat=2", "--repeat_interval=0", "--output_format=logfmt"})
+	ts.Require().NoError(err)
+	ts.Contains(cmd.RawStdErr, "msg=/a ip=10.0.0.1\n")
+	ts.Contains(cmd.RawStdErr, "msg=/b,c ip=10.0.0.2\n")
+}
+
+func (ts *ExecTestSuite) TestDataSetJSONL() {
+	file := filepath.Join(ts.T().TempDir(), "samples.jsonl")
+	ts.Require().NoError(os.WriteFile(file, []byte(`{"user":"amy","n":12345678901,"tags":["x"]}`+"\n\n"+`{"n":2.5}`+"\n"), 0644))
+
+	cmd, err := ts.ExecuteCmd([]string{"--stdout_data_set=" + file, "--stdout=__U__ __N__ __T__", "--interpolate=__U__=data_set:user",
+		"--interpolate=__N__=data_set:n", "--interpolate=__T__=data_set:tags", "--repeat=2", "--repeat_interval=0", "--output_format=raw"})
+	ts.Require().NoError(err)
+	ts.Equal("amy 12345678901 [\"x\"]\n 2.5 \n", cmd.RawStdOut)
+}
+
+func (ts *ExecTestSuite) TestDataSetValidation() {
+	dir := ts.T().TempDir()
+	csv := filepath.Join(dir, "samples.csv")
+	ts.Require().NoError(os.WriteFile(csv, []byte("ip\n10.0.0.1\n"), 0644))
+	txt := filepath.Join(dir, "samples.txt")
+	ts.Require().NoError(os.WriteFile(txt, []byte("ip\n"), 0644))
+	broken := filepath.Join(dir, "broken.jsonl")
+	ts.Require().NoError(os.WriteFile(broken, []byte("{\"ip\":\n"), 0644))
+
+	for _, tc := range []struct {
+		name string
+		args []string
+	}{
+		{"data_set key without a data set", []string{"--stdout=__IP__", "--interpolate=__IP__=data_set:ip"}},
+		{"column not in the data set", []string{"--stdout=__IP__", "--interpolate=__IP__=data_set:host", "--data_set=" + csv}},
+		{"unknown data set extension", []string{"--stdout=o", "--data_set=" + txt}},
+		{"broken jsonl", []string{"--stdout=o", "--data_set=" + broken}},
+		{"missing data set", []string{"--stdout=o", "--data_set=/does/not/exist.csv"}},
+	} {
+		ts.Run(tc.name, func() {
+			_, err := ts.ExecuteCmd(tc.args)
+			ts.ErrorAs(err, new(*paramSetValidationError), tc.args)
+		})
+	}
+}
@@ -22,7 +22,13 @@ templates:
   - `go mod init exectester`
   - `cobra-cli init`
 
-There are no subcommands just the root command.
+The root command does the actual output. There are also three subcommands:
+
+  - `et record -- <cmd>` ([cmd.recordCmd]) runs a real command and writes
+    its output, timing, signals and exit code to a capture file
+  - `et replay <file>` ([cmd.replayCmd]) plays a capture file back
+  - `et verify [file...]` ([cmd.verifyCmd]) checks output written with
+    --sequence for lost, duplicated, reordered or corrupted lines
 
 The root cobra Command ([github.com/spf13/cobra.Command]) is wrapped
 in a function ([cmd.RootCmd]) to make it testable. It calls another
@@ -35,6 +41,10 @@ These are are Cobra flags built from a custom type.
 
   - [cmd.outputFormatterEnum]
   - [cmd.interpolatorEnum]
+  - [cmd.rateModeEnum]
+  - [cmd.lineTerminatorEnum]
+  - [cmd.stackTraceEnum]
+  - [cmd.messageSelectionEnum]
 
 It takes an obnoxious amount of scaffolding to get Cobra + Viper to
 support flags from custom types.
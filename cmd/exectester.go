@@ -19,12 +19,13 @@ package cmd
 import (
 	"context"
 	"fmt"
+	"io"
 	"log/slog"
 	"os"
 	"os/signal"
-	"strconv"
+	"slices"
 	"strings"
-	"syscall"
+	"text/template"
 	"time"
 
 	"github.com/spf13/cobra"
@@ -33,39 +34,68 @@ import (
 
 const SocketDialTimeout int = 2
 
-// Custom type flags
-var interpolatorEnumVal interpolatorEnum
-var outputFormatterEnumVal OutputFormatter
-
 // Holds all the viper args that were retrieved and parsed
 type viperArgs struct {
 	outputFormatter        OutputFormatter
 	interpolatorEnumVal    interpolatorEnum
 	outputFormatterEnumVal OutputFormatter
+	outputFormat           string
 	stdout                 string
 	stderr                 string
 	socket                 string
 	socketSend             string
+	stdoutFile             string
+	stderrFile             string
+	socketSendFile         string
 	readSocket             bool
 	socketExitMsg          string
 	exitcode               int
-	repeat                 int
-	repeatInterval         time.Duration
-	repeatForever          bool
-	timeout                int
 	sigtermTimeout         int
-	interpolateKey         string
-	interpolator           string
-	interpolateVal         string
+	seed                   int64
+	runID                  string
+	scenario               []scenarioStep
+	interleave             []string
+	interleaveSeq          bool
+	floodStream            string
+	floodBytes             int
+	floodChunk             int
+	floodWarnAfter         time.Duration
+	grandchildHold         time.Duration
+	grandchildText         string
+	grandchildExe          string
+	signalActions          map[os.Signal]signalAction
+	drainMessages          []string
+	drainStream            string
+	shutdownStopOutput     bool
+	shutdownHang           time.Duration
+	shutdownExitcode       int
+	shutdownExitcodeSet    bool
+	secondSignal           string
+	crash                  *crasher
+	fields                 []outputField
+	// Whether stdout_messages or stderr_messages are set
+	streamMessagesSet map[string]bool
+	// The last --sequence number of each stream, kept over restarts and reloads
+	sequenceCounts map[string]*int
+	// The configured texts that are rendered as templates, parsed once
+	templates map[string]*template.Template
+	// The global repeat, timing and interpolation settings
+	streamArgs
+	// The same settings resolved per output stream. See forStream()
+	streams map[string]streamArgs
 }
 
 // Environment variable support is totally broken (sans hard coded config file)
 // Just doing it manually
 // https://stackoverflow.com/questions/67608629/viper-automatic-environment-does-not-read-from-environment
-func bindEnvToFlags() {
-	for k := range viper.AllSettings() {
-		envName := viper.GetEnvPrefix() + strings.ToUpper(k)
-		viper.BindEnv(k, envName)
+//
+// This binds the leaf keys (ie "streams.stderr.repeat" to
+// ET_STREAMS_STDERR_REPEAT). Binding a parent key like "streams" makes
+// viper.AllSettings() write flag defaults back into the config file's maps.
+func bindEnvToFlags(v *viper.Viper) {
+	for _, k := range v.AllKeys() {
+		envName := v.GetEnvPrefix() + strings.ToUpper(strings.ReplaceAll(k, ".", "_"))
+		v.BindEnv(k, envName)
 	}
 }
 
@@ -99,92 +129,308 @@ func paramSet(m map[string]any, k string) bool {
 // There might be support for doing this with flag groups
 // (https://github.com/spf13/cobra/issues/1936) but it seems like more
 // trouble then it was worth.
-func validateParamSets(cmd *cobra.Command) error {
-	m := viper.AllSettings()
+func validateParamSets(cmd *cobra.Command, v *viper.Viper) error {
+	m := v.AllSettings()
+	_, templateErr := parseRecordTemplate(v.GetString("output_template"))
+	renames, renamesErr := getFieldRenames(v)
+	outputFormat := v.GetString("output_format")
+	if outputFormat == "" {
+		outputFormat = string(outputFormatterEnumStructured)
+	}
 	switch {
-	case !paramSet(m, "stderr") && !paramSet(m, "stdout") && !paramSet(m, "socket") && !paramSet(m, "exitcode"):
-		return &paramSetValidationError{"you must specify at least stderr | stdout | socket | exitcode"}
-	case paramSet(m, "socket") && (!paramSet(m, "socket_send") && !paramSet(m, "read_socket")):
+	case messagesSet(v, "") && !paramSet(m, "stderr") && !paramSet(m, "stdout") && !paramSet(m, "socket") &&
+		!messagesSet(v, "stdout") && !messagesSet(v, "stderr"):
+		return &paramSetValidationError{"messages replace the text of a stream, set stdout | stderr | socket or use stdout_messages | stderr_messages"}
+	case !paramSet(m, "stderr") && !paramSet(m, "stdout") && !paramSet(m, "socket") && !paramSet(m, "exitcode") && !paramSet(m, "scenario") &&
+		!messagesSet(v, "stdout") && !messagesSet(v, "stderr") && !paramSet(m, "stdout_file") && !paramSet(m, "stderr_file") &&
+		!paramSet(m, "flood_stream") && !paramSet(m, "crash"):
+		return &paramSetValidationError{"you must specify at least stderr | stdout | socket | exitcode | scenario"}
+	case paramSet(m, "socket") && (!paramSet(m, "socket_send") && !paramSet(m, "read_socket") && !messagesSet(v, "") && !messagesSet(v, "socket") &&
+		!paramSet(m, "socket_send_file")):
 		return &paramSetValidationError{"if socket specified must also set socket_send and or read_socket"}
+	case paramSet(m, "flood_stream") && !slices.Contains([]string{"stdout", "stderr"}, v.GetString("flood_stream")):
+		return &paramSetValidationError{"'flood_stream' must be one of: stdout, stderr"}
+	case paramSet(m, "socket_send_file") && !paramSet(m, "socket"):
+		return &paramSetValidationError{"socket_send_file requires socket to be set"}
+	case !slices.Contains(lineTerminatorEnumValues, v.GetString("line_terminator")):
+		return &paramSetValidationError{fmt.Sprintf("'line_terminator' %v", lineTerminatorEnumValuesErrMsg)}
+	case v.GetString("output_format") == string(outputFormatterEnumTemplate) && templateErr != nil:
+		return &paramSetValidationError{fmt.Sprintf("invalid 'output_template': %v", templateErr.Error())}
+	case renamesErr != nil:
+		return &paramSetValidationError{renamesErr.Error()}
+	case (len(renames) > 0 || v.GetString("time_format") != "") && !slices.Contains(builtinKeyFormats, outputFormat):
+		return &paramSetValidationError{fmt.Sprintf("rename_field and time_format only work with the output formats: %v", builtinKeyFormats)}
 	default:
-		return nil
+		return validateStreamSettings(v)
 	}
 }
 
-// Reading user supplied input string and if interpolator token is found
-// replaces it per user interpolate input
-func interpolate(interpolateKeyArg string, interpolator string, outputText string, counter int, interpolateVal string) (string, error) {
-	var interpolated string
-	var errReturn error
-
-	// If no interpolator token is not found in outputText do nothing
-	if !strings.Contains(outputText, interpolateKeyArg) {
-		return outputText, nil
+// Parse the output_format flag into the OutputFormatter it names. Shared
+// with the subcommands since they write output the same way
+func getOutputFormatter(v *viper.Viper, fallbackLogger *slog.Logger) OutputFormatter {
+	var outputFormatterEnumVal OutputFormatter
+	err := v.UnmarshalKey("output_format", &outputFormatterEnumVal, viper.DecodeHook(outputFormatterEnumHookFunc(v)))
+	if err != nil {
+		fallbackLogger.Error("Failed to decode 'output_format' flag! Error: " + err.Error())
 	}
-
-	switch i := interpolator; i {
-	case "int_counter":
-		var startCounter int
-		if v, err := strconv.Atoi(interpolateVal); err == nil {
-			startCounter = v + counter
-			interpolated = strings.ReplaceAll(outputText, interpolateKeyArg, strconv.Itoa(startCounter))
-		} else {
-			errReturn = fmt.Errorf(fmt.Sprintf("'interpolate_val' of '%v' cannot be converted to a number! Defaulting to '0'",
-				interpolateVal))
-			startCounter = v + counter
-			interpolated = strings.ReplaceAll(outputText, interpolateKeyArg, strconv.Itoa(startCounter))
-		}
-	case "string":
-		interpolated = strings.ReplaceAll(outputText, interpolateKeyArg, interpolateVal)
-	default:
-		interpolated = strings.ReplaceAll(outputText, interpolateKeyArg, strconv.Itoa(counter))
+	if outputFormatterEnumVal.Raw {
+		outputFormatterEnumVal.LineTerminator = lineTerminators[lineTerminatorEnum(v.GetString("line_terminator"))]
 	}
-
-	return interpolated, errReturn
+	outputFormatterEnumVal.DecodeEscapes = v.GetBool("decode_escapes")
+	return outputFormatterEnumVal
 }
 
-// Collect and parse all Viper args, returning a struct holding their values
-func getViperArgs(fallbackLogger *slog.Logger) (viperArgs, error) {
-	// Parse viper flags from custom types
-	err := viper.UnmarshalKey("output_format", &outputFormatterEnumVal, viper.DecodeHook(outputFormatterEnumHookFunc))
-	if err != nil {
-		fallbackLogger.Error("Failed to decode 'output_format' flag! Error: ", err)
-	}
-	logger := outputFormatterEnumVal
+// Collect and parse all Viper args, returning a struct holding their values.
+// running is nil unless the config is being reloaded
+func getViperArgs(v *viper.Viper, fallbackLogger *slog.Logger, running *viperArgs) (viperArgs, error) {
+	logger := getOutputFormatter(v, fallbackLogger)
 
-	err = viper.UnmarshalKey("interpolator", &interpolatorEnumVal, viper.DecodeHook(interpolatorEnumHookFunc))
+	var interpolatorEnumVal interpolatorEnum
+	err := v.UnmarshalKey("interpolator", &interpolatorEnumVal, viper.DecodeHook(interpolatorEnumHookFunc))
 	if err != nil {
 		logger.Logger.Error("Failed to decode 'interpolator' flag!")
 	}
 
 	args := &viperArgs{
 		outputFormatter:        logger,
-		outputFormatterEnumVal: outputFormatterEnumVal,
+		outputFormatterEnumVal: logger,
+		outputFormat:           v.GetString("output_format"),
 		interpolatorEnumVal:    interpolatorEnumVal,
-		stdout:                 viper.GetString("stdout"),
-		stderr:                 viper.GetString("stderr"),
-		socket:                 viper.GetString("socket"),
-		socketSend:             viper.GetString("socket_send"),
-		readSocket:             viper.GetBool("read_socket"),
-		socketExitMsg:          viper.GetString("socket_exit_msg"),
-		exitcode:               viper.GetInt("exitcode"),
-		repeat:                 viper.GetInt("repeat"),
-		repeatInterval:         time.Duration(viper.GetInt("repeat_interval")) * time.Second,
-		repeatForever:          viper.GetBool("repeat_forever"),
-		timeout:                viper.GetInt("timeout"),
-		sigtermTimeout:         viper.GetInt("sigterm_timeout"),
-		interpolateKey:         viper.GetString("interpolate_key"),
-		interpolator:           viper.GetString("interpolator"),
-		interpolateVal:         viper.GetString("interpolate_val"),
+		stdout:                 v.GetString("stdout"),
+		stderr:                 v.GetString("stderr"),
+		socket:                 v.GetString("socket"),
+		socketSend:             v.GetString("socket_send"),
+		stdoutFile:             v.GetString("stdout_file"),
+		stderrFile:             v.GetString("stderr_file"),
+		socketSendFile:         v.GetString("socket_send_file"),
+		readSocket:             v.GetBool("read_socket"),
+		socketExitMsg:          v.GetString("socket_exit_msg"),
+		exitcode:               v.GetInt("exitcode"),
+		sigtermTimeout:         v.GetInt("sigterm_timeout"),
+		seed:                   v.GetInt64("seed"),
+		runID:                  v.GetString("run_id"),
+		interleaveSeq:          v.GetBool("interleave_seq"),
+		floodStream:            v.GetString("flood_stream"),
+		grandchildText:         v.GetString("grandchild_text"),
+		drainMessages:          v.GetStringSlice("drain_message"),
+		drainStream:            v.GetString("drain_stream"),
+		shutdownStopOutput:     v.GetBool("shutdown_stop_output"),
+		shutdownExitcode:       v.GetInt("shutdown_exitcode"),
+		shutdownExitcodeSet:    v.IsSet("shutdown_exitcode"),
+		secondSignal:           v.GetString("second_signal"),
+		streams:                map[string]streamArgs{},
+		streamMessagesSet:      map[string]bool{"stdout": messagesSet(v, "stdout"), "stderr": messagesSet(v, "stderr")},
+	}
+
+	// Pick a seed if one wasn't set. A reload keeps the one already picked
+	randomSeed := args.seed == 0
+	if randomSeed && running != nil {
+		args.seed, randomSeed = running.seed, false
+	} else if randomSeed {
+		args.seed = time.Now().UnixNano()
+	}
+
+	if args.floodStream != "" {
+		var fErr error
+		if args.floodBytes, fErr = parseSize(v.GetString("flood_bytes")); fErr != nil {
+			return *args, &paramSetValidationError{fmt.Sprintf("flood_bytes: %v", fErr.Error())}
+		}
+		if args.floodChunk, fErr = parseSize(v.GetString("flood_chunk")); fErr != nil {
+			return *args, &paramSetValidationError{fmt.Sprintf("flood_chunk: %v", fErr.Error())}
+		}
+		if args.floodWarnAfter, fErr = parseDuration(v.GetString("flood_warn_after")); fErr != nil {
+			return *args, &paramSetValidationError{fmt.Sprintf("flood_warn_after: %v", fErr.Error())}
+		}
+		if args.floodBytes <= 0 || args.floodChunk <= 0 {
+			return *args, &paramSetValidationError{"flood_bytes and flood_chunk must be > 0"}
+		}
+	}
+
+	var gErr error
+	if args.grandchildHold, gErr = parseDuration(v.GetString("grandchild_hold")); gErr != nil {
+		return *args, &paramSetValidationError{fmt.Sprintf("grandchild_hold: %v", gErr.Error())}
+	}
+	if args.grandchildHold < 0 {
+		return *args, &paramSetValidationError{"grandchild_hold can't be negative"}
+	}
+	if args.grandchildHold > 0 {
+		if args.grandchildExe, gErr = grandchildExe(v.GetString("grandchild_exe")); gErr != nil {
+			return *args, &paramSetValidationError{gErr.Error()}
+		}
+	}
+
+	if args.signalActions, err = getSignalActions(v); err != nil {
+		return *args, &paramSetValidationError{err.Error()}
+	}
+	if args.shutdownHang, err = parseDuration(v.GetString("shutdown_hang")); err != nil {
+		return *args, &paramSetValidationError{fmt.Sprintf("shutdown_hang: %v", err.Error())}
+	}
+	switch {
+	case args.shutdownHang < 0:
+		return *args, &paramSetValidationError{"shutdown_hang can't be negative"}
+	case !slices.Contains(outputStreams, args.drainStream):
+		return *args, &paramSetValidationError{fmt.Sprintf("'drain_stream' must be one of: %v", outputStreams)}
+	case args.drainStream == "socket" && len(args.drainMessages) > 0 && args.socket == "":
+		return *args, &paramSetValidationError{"'drain_stream' socket requires 'socket'"}
+	case !slices.Contains(secondSignalValues, args.secondSignal):
+		return *args, &paramSetValidationError{fmt.Sprintf("'second_signal' must be one of: %v", secondSignalValues)}
+	}
+
+	crashAfter, err := parseDuration(v.GetString("crash_after"))
+	if err != nil {
+		return *args, &paramSetValidationError{fmt.Sprintf("crash_after: %v", err.Error())}
+	}
+	if args.crash, err = newCrasher(v.GetString("crash"), crashAfter, v.GetInt("crash_after_lines"), logger); err != nil {
+		return *args, &paramSetValidationError{err.Error()}
+	}
+
+	// A reload keeps the run going so 'et verify' sees one run
+	if args.runID == "" && running != nil {
+		args.runID = running.runID
+	} else if args.runID == "" {
+		args.runID = newRunID()
+	}
+	if running != nil && args.runID == running.runID {
+		args.sequenceCounts = running.sequenceCounts
+	} else {
+		args.sequenceCounts = map[string]*int{}
+		for _, stream := range outputStreams {
+			args.sequenceCounts[stream] = new(int)
+		}
+	}
+
+	global, sErr := getStreamArgs(v, streamKey(v, ""))
+	if sErr != nil {
+		return *args, &paramSetValidationError{sErr.Error()}
+	}
+	args.streamArgs = global
+	for _, stream := range outputStreams {
+		s, sErr := getStreamArgs(v, streamKey(v, stream))
+		if sErr != nil {
+			return *args, &paramSetValidationError{fmt.Sprintf("%v: %v", stream, sErr.Error())}
+		}
+		args.streams[stream] = s
+
+		// Log the picked seed so a random run can be reproduced
+		random := slices.Contains([]rateModeEnum{rateModeEnumUniform, rateModeEnumPoisson, rateModeEnumExponential}, rateModeEnum(s.rateMode)) ||
+			len(s.levelDistribution) > 0 || usesPresets(s.interpolations) ||
+			(len(s.messages) > 1 && s.messageSelection != string(messageSelectionEnumRoundRobin))
+		if randomSeed && random {
+			logger.Logger.Info(fmt.Sprintf("Using random seed '%v'", args.seed))
+			randomSeed = false
+		}
+	}
+
+	for _, f := range []struct{ key, file string }{
+		{"stdout_file", args.stdoutFile}, {"stderr_file", args.stderrFile}, {"socket_send_file", args.socketSendFile},
+	} {
+		if f.file == "" {
+			continue
+		}
+		if _, err := os.Stat(f.file); err != nil {
+			return *args, &paramSetValidationError{fmt.Sprintf("%v: %v", f.key, err.Error())}
+		}
+	}
+
+	fields, fErr := getOutputFields(v)
+	if fErr != nil {
+		return *args, &paramSetValidationError{fErr.Error()}
+	}
+	args.fields = fields
+
+	if err := v.UnmarshalKey("scenario", &args.scenario); err != nil {
+		return *args, &paramSetValidationError{fmt.Sprintf("failed to decode 'scenario': %v", err.Error())}
+	}
+	if err := validateScenario(args.scenario, args.socket); err != nil {
+		return *args, err
+	}
+
+	if pattern := v.GetString("interleave"); pattern != "" {
+		if len(args.scenario) > 0 {
+			return *args, &paramSetValidationError{"interleave can't be used with a scenario"}
+		}
+		if args.interleave, err = parseInterleave(pattern); err != nil {
+			return *args, &paramSetValidationError{err.Error()}
+		}
+		if randomSeed && pattern == interleaveRandom {
+			logger.Logger.Info(fmt.Sprintf("Using random seed '%v'", args.seed))
+		}
+	}
+
+	// Streams that aren't written to can inherit a data_set interpolation without a data set
+	for _, stream := range outputStreams {
+		if !args.streamUsed(stream) {
+			continue
+		}
+		if err := checkDataSetColumns(args.streams[stream]); err != nil {
+			return *args, &paramSetValidationError{fmt.Sprintf("%v: %v", stream, err.Error())}
+		}
+	}
+
+	// Catch broken templates and unknown presets before any output is sent
+	var templates []string
+	texts := map[string][]string{"stdout": {args.stdout}, "stderr": {args.stderr}, "socket": {args.socketSend}}
+	for _, step := range args.scenario {
+		texts[step.Stream] = append(texts[step.Stream], step.Text)
+	}
+	for stream, s := range args.streams {
+		if s.interpolator == string(interpolatorEnumTemplate) {
+			templates = append(templates, texts[stream]...)
+			for _, m := range s.messages {
+				templates = append(templates, m.text)
+			}
+		}
+		for _, i := range s.interpolations {
+			if i.interpolator == string(interpolatorEnumTemplate) {
+				templates = append(templates, i.val)
+			}
+			if i.interpolator == string(interpolatorEnumPreset) {
+				if err := checkPreset(i.val); err != nil {
+					return *args, &paramSetValidationError{fmt.Sprintf("%v: %v", stream, err.Error())}
+				}
+			}
+		}
+	}
+	for _, f := range args.fields {
+		if f.template {
+			templates = append(templates, f.val)
+		}
+	}
+	args.templates = map[string]*template.Template{}
+	for _, t := range templates {
+		parsed, err := parseOutputTemplate(t)
+		if err != nil {
+			return *args, &paramSetValidationError{fmt.Sprintf("invalid template '%v': %v", t, err.Error())}
+		}
+		args.templates[t] = parsed
 	}
 
 	return *args, err
 }
 
+// Whether a stream is written to, by its flags or by a scenario step
+func (args viperArgs) streamUsed(stream string) bool {
+	if len(args.scenario) > 0 {
+		return slices.ContainsFunc(args.scenario, func(s scenarioStep) bool {
+			return s.Action == scenarioActionEmit && s.Stream == stream
+		})
+	}
+	switch stream {
+	case "stdout":
+		return args.stdout != "" || args.streamMessagesSet["stdout"] || args.stdoutFile != ""
+	case "stderr":
+		return args.stderr != "" || args.streamMessagesSet["stderr"] || args.stderrFile != ""
+	case "socket":
+		return args.socket != ""
+	}
+	return false
+}
+
 // Send and or read from unix socket. This func also parses args to
-// determine if sending or reading
-func outputSocket(cmd *cobra.Command, args viperArgs, outputText string) {
+// determine if sending or reading. Returns the number of bytes sent
+func outputSocket(ctx context.Context, cmd *cobra.Command, args viperArgs, outputText string) int {
 	logger := args.outputFormatter
+	sent := 0
 
 	s, err := getunixSocket(*logger.Logger, args.socket, SocketDialTimeout)
 	if err != nil {
@@ -193,9 +439,11 @@ func outputSocket(cmd *cobra.Command, args viperArgs, outputText string) {
 	defer s.close()
 
 	if args.socketSend != "" {
-		err := s.sendTounixSocket(outputText + "\n")
+		err := s.sendTounixSocket(ctx, outputText+"\n", logger.Partial)
 		if err != nil {
 			logger.Logger.Error(err.Error())
+		} else {
+			sent = len(outputText) + 1
 		}
 	}
 
@@ -204,116 +452,399 @@ func outputSocket(cmd *cobra.Command, args viperArgs, outputText string) {
 		if err != nil {
 			logger.Logger.Error(err.Error())
 		}
-		logger.cobraStdout(cmd, response)
+		logger.cobraStdout(ctx, cmd, response, slog.LevelInfo)
 	}
+
+	return sent
 }
 
-// Sends text to supported output locations
-func outputStream(cmd *cobra.Command, args viperArgs, outputStream string) {
-	counter := 0
-	startTime := time.Now()
-	timeout := time.Duration(args.timeout) * time.Second
+// Use correct method of output per output stream type
+// Returns the number of bytes written
+func emit(ctx context.Context, cmd *cobra.Command, args viperArgs, outputStream string, outputText string, level slog.Level, fields ...any) int {
 	logger := args.outputFormatter
 
-	// Pull text to output from right cli arg per output stream type
-	var outputText string
 	switch o := outputStream; o {
 	case "stdout":
-		outputText = args.stdout
+		return logger.cobraStdout(ctx, cmd, outputText, level, fields...)
 	case "stderr":
-		outputText = args.stderr
+		return logger.cobraStderr(ctx, cmd, outputText, level, fields...)
 	case "socket":
-		outputText = args.socketSend
+		// Scenarios emit to the socket without socket_send being set
+		args.socketSend = outputText
+		return outputSocket(ctx, cmd, args, outputText)
 	}
+	return 0
+}
+
+// Writes the lines of one output stream. Holds everything that carries over
+// from one line to the next
+type streamWriter struct {
+	cmd    *cobra.Command
+	args   viperArgs
+	stream string
+	// The lines of a file replace the text
+	file     *fileStreamer
+	repeat   int
+	counter  int
+	levels   *levelPicker
+	traces   *stackTracer
+	messages *messagePicker
+	sequence *sequencer
+	// Numbers the lines across streams for --interleave_seq. Nil if not set
+	global *globalSequence
+	// Times the writes when flooding. Nil if not set
+	timer *writeTimer
+	ictx  interpolateContext
+}
 
-	for ok := true; ok; {
-		interpolated, err := interpolate(args.interpolateKey, args.interpolator, outputText, counter, interpolateVal)
+// Set up a stream's writer. args must already be resolved for the stream (see forStream())
+func newStreamWriter(cmd *cobra.Command, args viperArgs, stream string) (*streamWriter, error) {
+	// Pull text to output from right cli arg per output stream type
+	var outputText, outputFile string
+	switch o := stream; o {
+	case "stdout":
+		outputText, outputFile = args.stdout, args.stdoutFile
+	case "stderr":
+		outputText, outputFile = args.stderr, args.stderrFile
+	case "socket":
+		outputText, outputFile = args.socketSend, args.socketSendFile
+	}
+
+	w := &streamWriter{
+		cmd:      cmd,
+		args:     args,
+		stream:   stream,
+		repeat:   args.repeat,
+		levels:   newLevelPicker(args, stream),
+		traces:   newStackTracer(args, stream),
+		messages: newMessagePicker(args, stream, outputText),
+		sequence: newSequencer(args, stream),
+		ictx:     newInterpolateContext(stream, args.seed, args.templates),
+	}
+	if args.floodStream != "" {
+		w.timer = &writeTimer{}
+	}
+	// Only this stream's lines are written in parts
+	w.args.outputFormatter.Partial = newPartialWriter(args)
+	if outputFile != "" {
+		f, err := openFileStreamer(outputFile, args)
 		if err != nil {
-			logger.Logger.Error(err.Error())
+			return nil, err
 		}
-
-		// Use correct method of output per output stream type
-		switch o := outputStream; o {
-		case "stdout":
-			logger.cobraStdout(cmd, interpolated)
-		case "stderr":
-			logger.cobraStderr(cmd, interpolated)
-		case "socket":
-			outputSocket(cmd, args, interpolated)
+		w.file = f
+		// A file runs until its end unless repeat is set
+		if !args.repeatSet {
+			w.repeat = 0
 		}
+	}
+	return w, nil
+}
 
-		time.Sleep(args.repeatInterval)
-		counter++
+func (w *streamWriter) close() {
+	if w.file != nil {
+		w.file.close()
+	}
+	if w.timer != nil {
+		w.timer.report(w.args.outputFormatter.Logger, fmt.Sprintf("Wrote to '%v'", w.stream))
+	}
+}
+
+// Write the next line. Returns the number of bytes written and whether the
+// stream is finished
+func (w *streamWriter) next(ctx context.Context) (int, bool) {
+	args := w.args
+	logger := args.outputFormatter
 
-		if args.timeout != 0 {
-			if time.Since(startTime) > timeout {
-				logger.Logger.Info(fmt.Sprintf("Timeout of '%v' was reached", strconv.Itoa(args.timeout)))
-				break
+	text := w.messages.pick()
+	if w.file != nil {
+		line, err := w.file.next(ctx)
+		if err != nil {
+			if err == context.DeadlineExceeded {
+				logger.Logger.Info(fmt.Sprintf("Timeout of '%v' was reached", args.timeout))
+			} else if err != io.EOF && err != context.Canceled {
+				logger.Logger.Error(err.Error())
 			}
+			return 0, true
 		}
-		if counter == args.repeat && !args.repeatForever {
-			break
+		text = line
+	}
+
+	lctx := w.ictx.next(w.counter)
+	lctx.Row = args.row(w.counter)
+	interpolated, err := interpolateStream(args, lctx, text)
+	if err != nil {
+		logger.Logger.Error(err.Error())
+	}
+	fields, err := interpolateFields(args, lctx)
+	if err != nil {
+		logger.Logger.Error(err.Error())
+	}
+
+	interpolated, fields = w.traces.apply(interpolated, fields)
+	interpolated, fields = w.global.apply(interpolated, fields)
+	interpolated, fields = w.sequence.apply(interpolated, fields)
+
+	last := w.counter+1 == w.repeat && !args.repeatForever
+	// Peeking doesn't block unless the file is followed
+	if w.file != nil && !args.fileFollow {
+		if _, err := w.file.peek(ctx); err == io.EOF {
+			last = true
 		}
 	}
+	if p := w.args.outputFormatter.Partial; p != nil {
+		p.final = last
+	}
+
+	level := w.levels.pick()
+	bytes := w.timer.time(logger.Logger, w.stream, args.floodWarnAfter, func() int {
+		return emit(ctx, w.cmd, args, w.stream, interpolated, level, fields...)
+	})
+	w.counter++
+	args.crash.line()
+	// Don't wait for another tick after the last line
+	return bytes, last
+}
+
+// Sends text to supported output locations until the stream is done or ctx
+// is cancelled. Returns whether the stream's timeout was reached
+func outputStream(ctx context.Context, cmd *cobra.Command, args viperArgs, outputStream string) bool {
+	args = args.forStream(outputStream)
+	logger := args.outputFormatter
+
+	w, err := newStreamWriter(cmd, args, outputStream)
+	if err != nil {
+		logger.Logger.Error(err.Error())
+		return false
+	}
+	defer w.close()
+
+	if args.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, args.timeout)
+		defer cancel()
+	}
+	sched := newScheduler(args.repeatInterval)
+	shaper := newRateShaper(args, outputStream)
+
+	for done := false; !done; {
+		// Most rate modes write 1 line per tick but burst and poisson write several
+		bytes := 0
+		for lines := shaper.lines(); lines > 0 && !done; lines-- {
+			n, finished := w.next(ctx)
+			bytes += n
+			done = finished
+		}
+
+		gap := shaper.gap(bytes)
+		if w.file != nil && args.filePacing && !done {
+			gap = w.file.gap(ctx)
+		}
+		if !done && !sched.waitFor(ctx, gap) {
+			if ctx.Err() == context.DeadlineExceeded {
+				logger.Logger.Info(fmt.Sprintf("Timeout of '%v' was reached", args.timeout))
+			}
+			done = true
+		}
+	}
+
+	if sched.missed > 0 {
+		logger.Logger.Warn(fmt.Sprintf("Missed '%v' ticks on '%v' because writes blocked", sched.missed, outputStream))
+	}
+	return ctx.Err() == context.DeadlineExceeded
 }
 
 // ExecTester() is called by the root cmd. The entire functionality of
-// the app is defined here.
-func ExecTester(cmd *cobra.Command, fallbackLogger *slog.Logger) error {
+// the app is defined here. Exit codes are returned as an *ExitError. v holds
+// the command's flags and config so several commands can run at once
+func ExecTester(cmd *cobra.Command, v *viper.Viper, fallbackLogger *slog.Logger) error {
 
-	bindEnvToFlags()
+	bindEnvToFlags(v)
 
-	if err := validateParamSets(cmd); err != nil {
-		return err
+	if err := validateParamSets(cmd, v); err != nil {
+		return validationExitError(err)
 	}
 
-	args, err := getViperArgs(fallbackLogger)
+	args, err := getViperArgs(v, fallbackLogger, nil)
+	if _, ok := err.(*paramSetValidationError); ok {
+		return validationExitError(err)
+	}
 	logger := args.outputFormatter
 
+	// Catch the signals that have an action before anything is written
+	sigs := make(chan os.Signal, 1)
+	for sig := range args.signalActions {
+		signal.Notify(sigs, sig)
+	}
+	defer signal.Stop(sigs)
+
+	// Started first so it holds the pipes however et exits
+	if args.grandchildHold > 0 {
+		pid, err := spawnGrandchild(args)
+		if err != nil {
+			logger.Logger.Error(fmt.Sprintf("Failed to start the grandchild: %v", err.Error()))
+		} else {
+			logger.Logger.Info(fmt.Sprintf("Started grandchild '%v' holding stdout and stderr for '%v'", pid, args.grandchildHold))
+		}
+	}
+
+	// Start the output. Returns the number of goroutines that send on c
+	// when they're done, true if their timeout was reached
 	c := make(chan bool)
-	// Catch signals to support a post sigterm timeout
-	// os.Interrupt is thrown when sending ctrl+c
-	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
-	defer stop()
-
-	// Send output to correct stream by checking cli args
-	if args.stdout != "" {
-		go func() {
-			outputStream(cmd, args, "stdout")
-			c <- true
-		}()
-	}
-	if args.stderr != "" {
-		go func() {
-			outputStream(cmd, args, "stderr")
-			c <- true
-		}()
-	}
-	if args.socket != "" {
-		go func() {
-			outputStream(cmd, args, "socket")
-			c <- true
-		}()
-	}
-
-	select {
-	case <-c:
-	case <-ctx.Done():
-		stop()
-		logger.Logger.Info(
-			fmt.Sprintf(
-				"Caught signal. Starting Sigterm timer to wait for "+
-					"'%s' seconds to shutdown. Output to console will "+
-					"continue while this timer is in effect",
-				strconv.Itoa(args.sigtermTimeout)))
-
-		t := time.Duration(args.sigtermTimeout) * time.Second
-		time.Sleep(t)
-	}
-
-	// If non zero exit immediately with that exit code
+	scenarioExitcode, scenarioExitcodeSet := 0, false
+	waiters := &signalWaiters{}
+	startOutput := func(ctx context.Context, args viperArgs) int {
+		// The flood is written before anything else
+		floodDone := make(chan struct{})
+		running := 0
+		if args.floodStream != "" {
+			running++
+			go func() {
+				flood(ctx, cmd, args)
+				close(floodDone)
+				c <- false
+			}()
+		} else {
+			close(floodDone)
+		}
+
+		// A scenario replaces the regular output streams
+		if len(args.scenario) > 0 {
+			running++
+			go func() {
+				<-floodDone
+				scenarioExitcode, scenarioExitcodeSet = runScenario(ctx, cmd, args, waiters)
+				c <- false
+			}()
+			return running
+		}
+
+		// Send output to correct stream by checking cli args
+		if len(args.interleave) > 0 {
+			running++
+			go func() {
+				<-floodDone
+				c <- outputInterleaved(ctx, cmd, args)
+			}()
+		}
+		for _, stream := range outputStreams {
+			if !args.streamUsed(stream) || args.interleaved(stream) {
+				continue
+			}
+			running++
+			go func(stream string) {
+				<-floodDone
+				c <- outputStream(ctx, cmd, args, stream)
+			}(stream)
+		}
+		return running
+	}
+
+	args.crash.start()
+	runCtx, cancelRun := context.WithCancel(context.Background())
+	running := startOutput(runCtx, args)
+	started, restarts := time.Now(), 0
+
+	// Wait for the output goroutines still running after cancelRun()
+	finished := 0
+	waitOutput := func() {
+		for ; finished < running; finished++ {
+			<-c
+		}
+	}
+
+	// Wait for every stream to finish or for a signal
+	signalCaught, timedOut := false, false
+	var shutdownErr error
+	for finished < running {
+		select {
+		case t := <-c:
+			finished++
+			timedOut = timedOut || t
+		case sig := <-sigs:
+			// A wait_signal step takes it before its action
+			if waiters.deliver(sig) {
+				continue
+			}
+			a := args.signalActions[sig]
+			switch a.action {
+			case signalActionIgnore:
+			case signalActionLog:
+				logger.Logger.Info(fmt.Sprintf("Caught signal '%v'", signalName(sig)))
+			case signalActionExit:
+				logger.Logger.Info(fmt.Sprintf("Caught signal '%v'. Exiting with '%v'", signalName(sig), a.code))
+				cancelRun()
+				waitOutput()
+				if a.code == 0 {
+					return nil
+				}
+				return &ExitError{Code: a.code, Cause: ExitCauseSignal, Signal: sig}
+			case signalActionKilled:
+				logger.Logger.Info(fmt.Sprintf("Caught signal '%v'. Exiting as if killed by it", signalName(sig)))
+				cancelRun()
+				waitOutput()
+				return &ExitError{Code: killedExitCode(sig), Cause: ExitCauseSignal, Signal: sig}
+			case signalActionDump:
+				dumpState(cmd, logger, started, restarts, running-finished)
+			case signalActionRestart, signalActionReload:
+				if a.action == signalActionReload {
+					reloaded, err := reloadViperArgs(cmd, v, fallbackLogger, args)
+					if err != nil {
+						logger.Logger.Error(fmt.Sprintf("Failed to reload, keeping the old config: %v", err.Error()))
+					} else {
+						// The crash is scheduled from when et started
+						reloaded.crash = args.crash
+						args, logger = reloaded, reloaded.outputFormatter
+						signal.Stop(sigs)
+						for sig := range args.signalActions {
+							signal.Notify(sigs, sig)
+						}
+					}
+				}
+
+				// Wait for the output to stop before starting it over
+				cancelRun()
+				waitOutput()
+				runCtx, cancelRun = context.WithCancel(context.Background())
+				// The flood is only written once
+				args.floodStream = ""
+				running, finished = startOutput(runCtx, args), 0
+				restarts++
+				logger.Logger.Info(fmt.Sprintf("Caught signal '%v'. Restarted the output", signalName(sig)))
+			case signalActionShutdown:
+				// The output goroutines still running keep reporting on c
+				outputDone := make(chan struct{})
+				go func(left int) {
+					for ; left > 0; left-- {
+						<-c
+					}
+					close(outputDone)
+				}(running - finished)
+
+				s := &shutdownSequence{cmd: cmd, v: v, args: args, sigs: sigs, waiters: waiters}
+				shutdownErr = s.run(cancelRun, outputDone)
+				finished = running
+				signalCaught = true
+			}
+		}
+	}
+	cancelRun()
+
+	args.crash.exit()
+
+	if shutdownErr != nil || (signalCaught && args.shutdownExitcodeSet) {
+		return shutdownErr
+	}
+
+	// The scenario goroutine is still running if a signal was caught
+	if !signalCaught && scenarioExitcodeSet {
+		return newExitError(scenarioExitcode, ExitCauseScenario)
+	}
+
 	if cmd.Flags().Lookup("exitcode").Changed {
-		os.Exit(args.exitcode)
+		if timedOut {
+			return newExitError(args.exitcode, ExitCauseTimeout)
+		}
+		return newExitError(args.exitcode, ExitCauseExitcode)
 	}
 
 	if err != nil {
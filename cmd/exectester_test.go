@@ -19,14 +19,19 @@ package cmd
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"net"
 	"os"
+	"os/exec"
+	"path/filepath"
 	"strconv"
+	"strings"
 	"sync"
 	"syscall"
 	"testing"
+	"text/template"
 	"time"
 
 	"github.com/benorgil/exectester/configs"
@@ -40,12 +45,17 @@ Grouping tests in a testify suite
 There is a huge limitation though, because testify
 doesn't seem to support any parallelism :(((
 https://github.com/stretchr/testify/issues/187
+
+Commands don't share any state though, so a test can run several of them
+at once, see TestConcurrentCommands
 */
 type ExecTestSuite struct {
 	suite.Suite
 }
 
 func TestExecTestSuite(t *testing.T) {
+	// Keep a $HOME/.exectester.yaml out of the tests
+	t.Setenv("HOME", t.TempDir())
 	suite.Run(t, &ExecTestSuite{})
 }
 
@@ -58,6 +68,9 @@ type CmdResult struct {
 	Pid         int
 	StdOutCount int
 	StdErrCount int
+	// Exactly what was written, before any parsing
+	RawStdOut string
+	RawStdErr string
 }
 
 // Spin up a test unix socket against local filesystem.
@@ -65,8 +78,10 @@ type CmdResult struct {
 // controlled environment- even locally. because of this I think its acceptable
 // to spin up a socket for some tests.
 // Abusing pointers here for testing. From the socket's side we need to assert
-// the data was send correctly and also send test data to listening clients
-func createTestSocket(ts *ExecTestSuite, socketFile string, mockResponse *string, assertReceived *string) {
+// the data was send correctly and also send test data to listening clients.
+// The returned channel receives once each connection has been handled.
+func createTestSocket(ts *ExecTestSuite, socketFile string, mockResponse *string, assertReceived *string) <-chan bool {
+	handled := make(chan bool, 10)
 	// Start socket and listen in background
 	os.Remove(socketFile)
 	go func(mockResponse *string) {
@@ -87,6 +102,7 @@ func createTestSocket(ts *ExecTestSuite, socketFile string, mockResponse *string
 
 			// Handle the connection in a separate goroutine.
 			go func(conn net.Conn) {
+				defer func() { handled <- true }()
 				defer conn.Close()
 
 				if *mockResponse != "" {
@@ -110,6 +126,8 @@ func createTestSocket(ts *ExecTestSuite, socketFile string, mockResponse *string
 			}(conn)
 		}
 	}(mockResponse)
+
+	return handled
 }
 
 // Reads stdout and stderr buffers and attempts to parse as json.
@@ -139,19 +157,21 @@ func (ts *ExecTestSuite) ExecuteCmd(args []string) (CmdResult, error) {
 	o := bytes.NewBufferString("")
 	e := bytes.NewBufferString("")
 
-	cmd := *RootCmd(configs.FallbackLogger)
+	// Not a copy, the subcommands find their output through their parent
+	cmd := RootCmd(configs.FallbackLogger)
 	cmd.SetOut(o)
 	cmd.SetErr(e)
 	cmd.SetArgs(args)
 
 	r := *new(CmdResult)
-	r.Cmd = cmd
+	r.Cmd = *cmd
 
 	err := cmd.Execute()
 
 	if err != nil {
 		return r, err
 	} else {
+		r.RawStdOut, r.RawStdErr = o.String(), e.String()
 		r.StdOut, r.StdOutCount, r.IsJson = readB(o)
 		r.StdErr, r.StdErrCount, r.IsJson = readB(e)
 	}
@@ -159,9 +179,55 @@ func (ts *ExecTestSuite) ExecuteCmd(args []string) (CmdResult, error) {
 	return r, err
 }
 
+// Same as ExecuteCmd() but with the given yaml as the config file
+func (ts *ExecTestSuite) ExecuteCmdWithConfig(config string, args []string) (CmdResult, error) {
+	f := filepath.Join(ts.T().TempDir(), "exectester.yaml")
+	ts.Require().NoError(os.WriteFile(f, []byte(config), 0644))
+
+	return ts.ExecuteCmd(append([]string{"--config=" + f}, args...))
+}
+
+// Every command has its own viper and flags so commands with different
+// settings can run side by side in one process
+func (ts *ExecTestSuite) TestConcurrentCommands() {
+	const n = 8
+	results := make([]CmdResult, n)
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			args := []string{fmt.Sprintf("--stdout=cmd%v __I__", i), fmt.Sprintf("--interpolate_val=%v", i*100),
+				"--repeat=3", "--repeat_interval=10ms"}
+			if i%2 == 0 {
+				args = append(args, "--output_format=raw")
+			}
+			results[i], errs[i] = ts.ExecuteCmd(args)
+		}(i)
+	}
+	wg.Wait()
+
+	for i := 0; i < n; i++ {
+		ts.Require().NoError(errs[i], i)
+		want := []string{}
+		raw := ""
+		for j := 0; j < 3; j++ {
+			want = append(want, fmt.Sprintf("cmd%v %v", i, i*100+j))
+			raw += want[j] + "\n"
+		}
+		ts.Equal(want, results[i].StdOut, i)
+		if i%2 == 0 {
+			ts.Equal(raw, results[i].RawStdOut, i)
+		} else {
+			ts.NotEqual(raw, results[i].RawStdOut, i)
+		}
+	}
+}
+
 func (ts *ExecTestSuite) TestNoArgs() {
 	_, err := ts.ExecuteCmd([]string{""})
-	ts.IsType(&paramSetValidationError{}, err)
+	ts.ErrorAs(err, new(*paramSetValidationError))
 }
 
 func (ts *ExecTestSuite) TestStdoutStderr() {
@@ -171,22 +237,57 @@ func (ts *ExecTestSuite) TestStdoutStderr() {
 	ts.Equal("e", cmd.StdErr[0])
 }
 
+func (ts *ExecTestSuite) TestRawOutput() {
+	cmd, err := ts.ExecuteCmd([]string{"--stdout=a\\tb\\x1b[0m\\u00e9", "--stderr=e__I__", "--repeat=2", "--repeat_interval=0",
+		"--output_format=raw", "--decode_escapes"})
+	ts.NoError(err)
+	ts.Equal("a\tb\x1b[0m\u00e9\na\tb\x1b[0m\u00e9\n", cmd.RawStdOut)
+	ts.Equal("e0\ne1\n", cmd.RawStdErr)
+
+	// Escapes are left alone unless asked for
+	cmd, err = ts.ExecuteCmd([]string{"--stdout=a\\tb", "--repeat=2", "--repeat_interval=0",
+		"--output_format=raw", "--line_terminator=nul"})
+	ts.NoError(err)
+	ts.Equal("a\\tb\x00a\\tb\x00", cmd.RawStdOut)
+
+	cmd, err = ts.ExecuteCmd([]string{"--stdout=x", "--repeat=2", "--repeat_interval=0",
+		"--output_format=raw", "--line_terminator=crlf"})
+	ts.NoError(err)
+	ts.Equal("x\r\nx\r\n", cmd.RawStdOut)
+
+	cmd, err = ts.ExecuteCmd([]string{"--stdout=x", "--repeat=2", "--repeat_interval=0",
+		"--output_format=raw", "--line_terminator=none"})
+	ts.NoError(err)
+	ts.Equal("xx", cmd.RawStdOut)
+
+	_, err = ts.ExecuteCmdWithConfig("line_terminator: cr", []string{"--stdout=x", "--output_format=raw"})
+	ts.ErrorAs(err, new(*paramSetValidationError))
+}
+
+func (ts *ExecTestSuite) TestDecodeEscapeSequences() {
+	ts.Equal("\t\n\x1b\x1b\xff\u00e9\\", decodeEscapeSequences(`\t\n\e\x1b\xff\u00e9\\`))
+	// Unknown or broken escapes are left as typed
+	ts.Equal(`\q \x1 \"`, decodeEscapeSequences(`\q \x1 \"`))
+}
+
 func (ts *ExecTestSuite) TestUnixSocket() {
 	// Open the test unix socket
 	socketFile := "/tmp/ExecTestSuite_TestUnixSocket.sock"
 	socketArg := fmt.Sprintf("--socket=%s", socketFile)
 	mockResponse := ""
 	assertReceived := ""
-	createTestSocket(ts, socketFile, &mockResponse, &assertReceived)
+	handled := createTestSocket(ts, socketFile, &mockResponse, &assertReceived)
 
 	// Send data to test socket
 	// assertReceived pointer used by TestSocket to assert msg received
 	assertReceived = "test_msg_01\n"
 	_, err := ts.ExecuteCmd([]string{socketArg, "--socket_send=test_msg_01"})
 	ts.NoError(err)
+	<-handled
 	assertReceived = "test_msg_02\n"
 	_, err = ts.ExecuteCmd([]string{socketArg, "--socket_send=test_msg_02"})
 	ts.NoError(err)
+	<-handled
 
 	// Read data from the socket's buffer and close client when we get the exit_message
 	// mockResponse pointer used by TestSocket to send response to client
@@ -201,6 +302,34 @@ func (ts *ExecTestSuite) TestRepeat() {
 	ts.Equal(2, cmd.StdOutCount)
 }
 
+func (ts *ExecTestSuite) TestPerStreamSettings() {
+	cmd, err := ts.ExecuteCmd([]string{"--stdout=o__I__", "--stderr=e__I__", "--repeat=3", "--repeat_interval=0",
+		"--stderr_repeat=1", "--stderr_interpolator=string", "--stderr_interpolate_val=zzz"})
+	ts.NoError(err)
+	ts.Equal([]string{"o0", "o1", "o2"}, cmd.StdOut)
+	ts.Equal([]string{"ezzz"}, cmd.StdErr)
+}
+
+func (ts *ExecTestSuite) TestPerStreamSettingsConfig() {
+	config := `
+streams:
+  stdout:
+    repeat: 2
+  stderr:
+    interpolate_val: 10
+`
+	cmd, err := ts.ExecuteCmdWithConfig(config, []string{"--stdout=o__I__", "--stderr=e__I__", "--repeat=3", "--repeat_interval=0"})
+	ts.NoError(err)
+	ts.Equal([]string{"o0", "o1"}, cmd.StdOut)
+	ts.Equal([]string{"e10", "e11", "e12"}, cmd.StdErr)
+
+	_, err = ts.ExecuteCmdWithConfig("streams: {stdin: {repeat: 2}}", []string{"--stdout=o"})
+	ts.ErrorAs(err, new(*paramSetValidationError))
+
+	_, err = ts.ExecuteCmdWithConfig("streams: {stdout: {interpolator: nope}}", []string{"--stdout=o"})
+	ts.ErrorAs(err, new(*paramSetValidationError))
+}
+
 func (ts *ExecTestSuite) TestDefaultInterpolator() {
 	// Default is int_counter
 	cmd, _ := ts.ExecuteCmd([]string{"--stdout=o__I__o", "--stderr=e__I__e", "--repeat=3"})
@@ -221,12 +350,70 @@ func (ts *ExecTestSuite) TestStringInterpolator() {
 	ts.Equal("ezzze", cmd.StdErr[1])
 }
 
+func (ts *ExecTestSuite) TestMultipleInterpolations() {
+	cmd, err := ts.ExecuteCmd([]string{"--stdout=__I__ n=__N__ host=__HOST__ again=__N__", "--repeat=2", "--repeat_interval=0",
+		"--interpolate=__N__=int_counter:100", "--interpolate=__HOST__=string:web1", "--interpolate_val=7"})
+	ts.NoError(err)
+	ts.Equal("8 n=101 host=web1 again=101", cmd.StdOut[1])
+
+	cmd, err = ts.ExecuteCmd([]string{"--stdout=__TS__", "--interpolate=__TS__=timestamp:rfc3339"})
+	ts.NoError(err)
+	_, err = time.Parse(time.RFC3339, cmd.StdOut[0])
+	ts.NoError(err)
+
+	_, err = ts.ExecuteCmd([]string{"--stdout=o", "--interpolate=__X__=nope:1"})
+	ts.ErrorAs(err, new(*paramSetValidationError))
+}
+
+func (ts *ExecTestSuite) TestInterpolationsConfigMap() {
+	config := `
+interpolate:
+  __N__: int_counter:100
+  __HOST__: string:web1
+`
+	cmd, err := ts.ExecuteCmdWithConfig(config, []string{"--stdout=n=__N__ host=__HOST__", "--repeat=2", "--repeat_interval=0"})
+	ts.NoError(err)
+	ts.Equal("n=101 host=web1", cmd.StdOut[1])
+}
+
+func (ts *ExecTestSuite) TestTemplateInterpolator() {
+	cmd, err := ts.ExecuteCmd([]string{"--stdout={{.Stream}}-{{pad 3 .Counter}}", "--stderr={{.Stream}}:{{len uuid}}",
+		"--repeat=2", "--repeat_interval=0", "--interpolator=template"})
+	ts.NoError(err)
+	ts.Equal("stdout-001", cmd.StdOut[1])
+	ts.Equal("stderr:36", cmd.StdErr[1])
+
+	// randInt follows the seed
+	seeded := []string{"--stdout={{randInt 0 1000000}}", "--repeat=5", "--repeat_interval=0",
+		"--interpolator=template", "--output_format=raw", "--seed=7"}
+	first, err := ts.ExecuteCmd(seeded)
+	ts.NoError(err)
+	second, err := ts.ExecuteCmd(seeded)
+	ts.NoError(err)
+	ts.Equal(first.RawStdOut, second.RawStdOut)
+
+	// Only the configured texts are kept parsed, not every line of a file
+	configured, err := parseOutputTemplate("{{.Stream}}")
+	ts.Require().NoError(err)
+	ctx := newInterpolateContext("stdout", 1, map[string]*template.Template{"{{.Stream}}": configured})
+	for _, text := range []string{"{{.Stream}}", "{{.Stream}} 1", "{{.Stream}} 2"} {
+		out, err := renderTemplate(text, ctx)
+		ts.NoError(err)
+		ts.True(strings.HasPrefix(out, "stdout"))
+	}
+	ts.Len(ctx.templates, 1)
+
+	_, err = ts.ExecuteCmd([]string{"--stdout={{.Broken", "--interpolator=template"})
+	ts.ErrorAs(err, new(*paramSetValidationError))
+}
+
 func (ts *ExecTestSuite) TestTimeout() {
-	now := time.Now().Round(time.Second)
-	expectedTimeout := (time.Duration(3) * time.Second).Round(time.Second)
+	now := time.Now()
 	cmd, _ := ts.ExecuteCmd([]string{"--stdout=o", "--repeat_forever", "--timeout=3"})
 	ts.Equal("o", cmd.StdOut[0])
-	ts.Equal(expectedTimeout, time.Since(now).Round(time.Second))
+	// The timeout starts after the command is set up so only the lower bound is tight
+	ts.GreaterOrEqual(time.Since(now), 3*time.Second)
+	ts.Less(time.Since(now), 3*time.Second+500*time.Millisecond)
 }
 
 func (ts *ExecTestSuite) TestSigTermTimeout() {
@@ -266,13 +453,66 @@ func (ts *ExecTestSuite) TestRepeatInterval() {
 	timeoutArg := 2 // Must be Divisible by repeatIntervalArg for test to pass
 	repeatIntervalArg := 1
 
-	expectedTimeout := (time.Duration(timeoutArg) * time.Second).Round(time.Second)
-	now := time.Now().Round(time.Second)
+	expectedTimeout := time.Duration(timeoutArg) * time.Second
+	now := time.Now()
 	cmd, _ := ts.ExecuteCmd([]string{"--stdout=o", "--repeat_forever",
 		fmt.Sprintf("--repeat_interval=%s", strconv.Itoa(repeatIntervalArg)),
 		fmt.Sprintf("--timeout=%s", strconv.Itoa(timeoutArg))})
 	ts.Equal("o", cmd.StdOut[0])
-	ts.Equal(expectedTimeout, time.Since(now).Round(time.Second))
+	ts.GreaterOrEqual(time.Since(now), expectedTimeout)
+	ts.Less(time.Since(now), expectedTimeout+500*time.Millisecond)
+}
+
+func (ts *ExecTestSuite) TestSubSecondRepeatInterval() {
+	now := time.Now()
+	cmd, err := ts.ExecuteCmd([]string{"--stdout=o", "--repeat_forever", "--repeat_interval=100ms", "--timeout=1s"})
+	ts.NoError(err)
+	// Ticks at 0ms, 100ms ... 900ms. The one landing on the deadline never fires
+	// and a slow machine can miss a tick or two
+	ts.InDelta(10, cmd.StdOutCount, 2)
+	ts.GreaterOrEqual(time.Since(now), time.Second)
+	ts.Less(time.Since(now), 3*time.Second)
+
+	_, err = ts.ExecuteCmd([]string{"--stdout=o", "--repeat_interval=soon"})
+	ts.ErrorAs(err, new(*paramSetValidationError))
+}
+
+func (ts *ExecTestSuite) TestSchedulerMissedTicks() {
+	// Pretend a write blocked through 3 ticks
+	s := &scheduler{interval: 100 * time.Millisecond, next: time.Now().Add(-350 * time.Millisecond)}
+	ts.True(s.wait(context.Background()))
+	ts.Equal(2, s.missed)
+
+	// The next tick lands after the deadline so it never fires
+	s = newScheduler(time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	now := time.Now()
+	ts.False(s.wait(ctx))
+	ts.Less(time.Since(now), time.Second)
+}
+
+func (ts *ExecTestSuite) TestRateModes() {
+	cmd, err := ts.ExecuteCmd([]string{"--stdout=o", "--repeat_forever", "--timeout=1s", "--rate_mode=lines_per_sec", "--rate=200"})
+	ts.NoError(err)
+	ts.InDelta(200, cmd.StdOutCount, 10)
+
+	cmd, err = ts.ExecuteCmd([]string{"--stdout=o", "--repeat_forever", "--timeout=500ms", "--rate_mode=burst",
+		"--burst_size=5", "--repeat_interval=200ms"})
+	ts.NoError(err)
+	ts.Equal(15, cmd.StdOutCount)
+
+	// The same seed gives the same output
+	poisson := []string{"--stdout=o", "--repeat_forever", "--timeout=500ms", "--rate_mode=poisson",
+		"--rate=3", "--repeat_interval=50ms", "--seed=7"}
+	first, err := ts.ExecuteCmd(poisson)
+	ts.NoError(err)
+	second, err := ts.ExecuteCmd(poisson)
+	ts.NoError(err)
+	ts.Equal(first.StdOutCount, second.StdOutCount)
+
+	_, err = ts.ExecuteCmd([]string{"--stdout=o", "--rate_mode=bytes_per_sec"})
+	ts.ErrorAs(err, new(*paramSetValidationError))
 }
 
 func (ts *ExecTestSuite) TestOutputFormat() {
@@ -284,45 +524,40 @@ func (ts *ExecTestSuite) TestOutputFormat() {
 	ts.Equal(false, cmd.IsJson)
 }
 
-func SetExitCodeFlag() {
-	ts := new(ExecTestSuite)
-	ts.ExecuteCmd([]string{"--exitcode=123"})
-}
+func (ts *ExecTestSuite) TestExitCode() {
+	cmd, err := ts.ExecuteCmd([]string{"--stdout=o", "--exitcode=123"})
+	var exitErr *ExitError
+	ts.Require().ErrorAs(err, &exitErr)
+	ts.Equal(&ExitError{Code: 123, Cause: ExitCauseExitcode}, exitErr)
+	ts.Equal(123, ExitCode(err))
+	// It's not a usage error
+	ts.NotContains(cmd.RawStdErr, "Usage:")
+
+	_, err = ts.ExecuteCmd([]string{"--stdout=o", "--repeat_forever", "--repeat_interval=50ms", "--timeout=200ms", "--exitcode=3"})
+	ts.Require().ErrorAs(err, &exitErr)
+	ts.Equal(ExitCauseTimeout, exitErr.Cause)
 
-// // TODO this decided to stop working for some reason
-// // TODO IM HERE try running it through the dagger pipeline maybe it works there
-// // For capturing exit code, call go test itself as a subprocess that then calls SetExitCode()
-// // which calls the Cobra command passing it the --exitcode flag... whew!
-// // https://stackoverflow.com/questions/26225513/how-to-test-os-exit-scenarios-in-go
-// // https://go.dev/talks/2014/testing.slide
-// func (ts *ExecTestSuite) TestExitCode() {
-// 	if os.Getenv("FORCE_EXIT") == "1" {
-// 		SetExitCodeFlag()
-// 		return
-// 	}
-//
-// 	// Im not entirely sure why but previously passing "" worked but pointing to the test itself did not
-// 	// Now I can't get it to work at all :(((
-// 	// gotestArgs := "-run ^TestExecTestSuite$ -testify.m ^(TestExitCode)"
-// 	// gotestArgs := "'-test.run' '^TestExecTestSuite/TestExitCode$'"
-// 	gotestArgs := ""
-// 	cmd := exec.Command(os.Args[0], gotestArgs)
-//
-// 	cmd.Env = append(os.Environ(), "FORCE_EXIT=1")
-//
-// 	fmt.Printf("%+v\n", cmd.Args)
-// 	/////////////////////////////
-// 	cmd.Stdout = os.Stdout
-// 	cmd.Run()
-//
-// 	// out, err := cmd.CombinedOutput()
-// 	// if err != nil {
-// 	// 	exitCode := err.(*exec.ExitError).ExitCode()
-// 	// 	ts.Equal(123, exitCode)
-// 	// 	if exitCode != 123 {
-// 	// 		ts.T().Log("TestExitCode() failed. Output from exec: " + string(out))
-// 	// 	}
-// 	// } else {
-// 	// 	ts.T().Log("TestExitCode() failed. Output from exec: " + string(out))
-// 	// }
-// }
+	_, err = ts.ExecuteCmd([]string{"--stdout=o", "--exitcode=0"})
+	ts.NoError(err)
+	ts.Equal(0, ExitCode(err))
+
+	_, err = ts.ExecuteCmd([]string{""})
+	ts.Require().ErrorAs(err, &exitErr)
+	ts.Equal(ExitCauseValidation, exitErr.Cause)
+	ts.Equal(2, ExitCode(err))
+
+	// main() exits with the code
+	testArgExePath, present := os.LookupEnv(configs.TestArgExePath)
+	ts.Require().True(present, exe_err)
+	for args, code := range map[string]int{"--exitcode=123": 123, "--stdout_repeat=-1": 2} {
+		c := exec.Command(testArgExePath, "--stdout=o", args)
+		c.Run()
+		ts.Equal(code, c.ProcessState.ExitCode(), args)
+	}
+	// The subcommands' flags are validated the same way
+	for _, args := range [][]string{{"verify", "--expect=-1"}, {"replay", "--speed=-1", "capture.json"}} {
+		c := exec.Command(testArgExePath, args...)
+		c.Run()
+		ts.Equal(2, c.ProcessState.ExitCode(), args)
+	}
+}
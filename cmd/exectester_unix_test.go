@@ -0,0 +1,91 @@
+//go:build unix
+
+/*
+Copyright © 2023 Ben Orgil
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/benorgil/exectester/configs"
+)
+
+// et running as a subprocess for the tests that signal it. Signalling the
+// test process instead kills the whole test binary if the signal arrives
+// before et catches it
+type etProcess struct {
+	cmd    *exec.Cmd
+	out    *bufio.Reader
+	stdout strings.Builder
+	stderr bytes.Buffer
+	killer *time.Timer
+}
+
+// Start et. It's killed if it's still running after timeout so a signal that
+// went missing fails the test instead of hanging it
+func (ts *ExecTestSuite) startEt(timeout time.Duration, args ...string) *etProcess {
+	testArgExePath, present := os.LookupEnv(configs.TestArgExePath)
+	ts.Require().True(present, exe_err)
+
+	p := &etProcess{cmd: exec.Command(testArgExePath, args...)}
+	p.cmd.Stderr = &p.stderr
+	pipe, err := p.cmd.StdoutPipe()
+	ts.Require().NoError(err)
+	p.out = bufio.NewReader(pipe)
+	ts.Require().NoError(p.cmd.Start())
+	p.killer = time.AfterFunc(timeout, func() { p.cmd.Process.Kill() })
+	return p
+}
+
+// Read stdout until a line containing text, ie the first line of output, so a
+// signal sent after this is caught by et. False if et exited first
+func (p *etProcess) waitFor(text string) bool {
+	for {
+		line, err := p.out.ReadString('\n')
+		p.stdout.WriteString(line)
+		if strings.Contains(line, text) {
+			return true
+		}
+		if err != nil {
+			return false
+		}
+	}
+}
+
+// Wait for et to exit. False if it had to be killed after the timeout
+func (p *etProcess) wait() bool {
+	io.Copy(&p.stdout, p.out)
+	p.cmd.Wait()
+	return p.killer.Stop()
+}
+
+func (ts *ExecTestSuite) TestExitCodeSignal() {
+	p := ts.startEt(10*time.Second, "--stdout=o", "--repeat_forever", "--repeat_interval=50ms",
+		"--on_signal=SIGUSR1=killed")
+	ts.Require().True(p.waitFor(`"msg":"o"`))
+	ts.Require().NoError(p.cmd.Process.Signal(syscall.SIGUSR1))
+	ts.Require().True(p.wait(), p.stdout.String())
+	ts.Equal(128+int(syscall.SIGUSR1), p.cmd.ProcessState.ExitCode(), p.stderr.String())
+	ts.Contains(p.stdout.String(), "Caught signal 'SIGUSR1'. Exiting as if killed by it")
+}
@@ -0,0 +1,125 @@
+/*
+Copyright © 2023 Ben Orgil
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+// Why et exits with a code
+type ExitCause string
+
+const (
+	// --exitcode
+	ExitCauseExitcode ExitCause = "exitcode"
+	// --exitcode after a stream's timeout was reached
+	ExitCauseTimeout ExitCause = "timeout"
+	// An on_signal action or a signal caught while shutting down
+	ExitCauseSignal ExitCause = "signal"
+	// --shutdown_exitcode
+	ExitCauseShutdown ExitCause = "shutdown"
+	// An exit step of the scenario
+	ExitCauseScenario ExitCause = "scenario"
+	// The flags or the config file are invalid
+	ExitCauseValidation ExitCause = "validation"
+	// The exit code of the command 'et record' ran or 'et replay' played
+	ExitCauseCommand ExitCause = "command"
+	// 'et verify' found problems
+	ExitCauseVerify ExitCause = "verify"
+)
+
+// Exit code of a validation failure, like the flag package's usage errors
+const validationExitCode = 2
+
+/*
+Returned by the commands instead of calling os.Exit so the code can be
+checked in-process and et can be embedded in other Go programs and tests:
+
+	err := cmd.Execute()
+	var exitErr *ExitError
+	if errors.As(err, &exitErr) && exitErr.Cause == ExitCauseSignal {
+		...
+	}
+
+main() exits with ExitCode(err).
+*/
+type ExitError struct {
+	Code  int
+	Cause ExitCause
+	// The signal for ExitCauseSignal
+	Signal os.Signal
+	// What went wrong for ExitCauseValidation
+	Err error
+}
+
+func (e *ExitError) Error() string {
+	switch {
+	case e.Err != nil:
+		return e.Err.Error()
+	case e.Signal != nil:
+		return fmt.Sprintf("exit status %v (%v '%v')", e.Code, e.Cause, signalName(e.Signal))
+	default:
+		return fmt.Sprintf("exit status %v (%v)", e.Code, e.Cause)
+	}
+}
+
+func (e *ExitError) Unwrap() error {
+	return e.Err
+}
+
+// Returns nil for a 0 code so a command can return it as is
+func newExitError(code int, cause ExitCause) error {
+	if code == 0 {
+		return nil
+	}
+	return &ExitError{Code: code, Cause: cause}
+}
+
+// Wrap a paramSetValidationError. Other errors are returned as is
+func validationExitError(err error) error {
+	if _, ok := err.(*paramSetValidationError); ok {
+		return &ExitError{Code: validationExitCode, Cause: ExitCauseValidation, Err: err}
+	}
+	return err
+}
+
+// The exit code for an error returned by a command. 0 if there's none and
+// 1 if it's not an ExitError
+func ExitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	var exitErr *ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.Code
+	}
+	return 1
+}
+
+// Cobra prints any error RunE returns along with the usage. An exit code
+// isn't a usage error so only validation failures keep that
+func silenceExit(cmd *cobra.Command, err error) error {
+	var exitErr *ExitError
+	if errors.As(err, &exitErr) && exitErr.Cause != ExitCauseValidation {
+		cmd.SilenceErrors, cmd.SilenceUsage = true, true
+	}
+	return err
+}
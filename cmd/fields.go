@@ -0,0 +1,212 @@
+/*
+Copyright © 2023 Ben Orgil
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"log/slog"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+/*
+Extra fields added to every line of structured output, so `et` can look like
+the services whose logs it stands in for:
+
+	et --stdout='request done' --field=service=api --field=req='{{uuid}}' \
+		--field=n=__I__ --rename_field=msg=message --rename_field=time=@timestamp \
+		--time_format=unix_ms
+
+or in the config file:
+
+	fields:
+	  service: api
+	  req: "{{uuid}}"
+	rename_fields:
+	  msg: message
+
+Values containing '{{' are rendered as templates (see interpolateContext) on
+every line, then the stream's interpolation keys are replaced. Viper
+lowercases the keys of config maps so use --field for names with capitals.
+
+The other output formats have a fixed schema, so renames and --time_format
+only work with the structured and logfmt formats.
+*/
+type outputField struct {
+	key      string
+	val      string
+	template bool
+}
+
+// The value of a field. Marked so builtinReplaceAttr() leaves a field named
+// like a built-in key alone, ie --field=msg=x
+type fieldValue string
+
+// The built-in keys that can be renamed
+var renameableFields = []string{slog.TimeKey, slog.LevelKey, slog.MessageKey}
+
+// The output formats writing the built-in keys, see builtinReplaceAttr()
+var builtinKeyFormats = []string{string(outputFormatterEnumStructured), string(outputFormatterEnumLogfmt)}
+
+func newOutputField(key string, val string) outputField {
+	return outputField{key: key, val: val, template: strings.Contains(val, "{{")}
+}
+
+// Read a "key=value" list or a map of keys to values from viper. Used for
+// both fields and rename_fields
+func getKeyValues(v *viper.Viper, key string) ([][2]string, error) {
+	var kvs [][2]string
+	parse := func(def string) error {
+		k, val, ok := strings.Cut(def, "=")
+		if !ok || k == "" {
+			return fmt.Errorf("%v '%v' must look like key=value", key, def)
+		}
+		kvs = append(kvs, [2]string{k, val})
+		return nil
+	}
+
+	switch val := v.Get(key).(type) {
+	case []string:
+		for _, d := range val {
+			if err := parse(d); err != nil {
+				return nil, err
+			}
+		}
+	case []any:
+		for _, d := range val {
+			if err := parse(fmt.Sprint(d)); err != nil {
+				return nil, err
+			}
+		}
+	case map[string]any:
+		// Sorted so the fields come out in the same order every time
+		keys := make([]string, 0, len(val))
+		for k := range val {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			kvs = append(kvs, [2]string{k, fmt.Sprint(val[k])})
+		}
+	}
+	return kvs, nil
+}
+
+// Collect the fields from "fields" in the config file and --field. Both end
+// up under the "field" key since the flag is bound to it
+func getOutputFields(v *viper.Viper) ([]outputField, error) {
+	var fields []outputField
+	for _, key := range []string{"fields", "field"} {
+		kvs, err := getKeyValues(v, key)
+		if err != nil {
+			return nil, err
+		}
+		for _, kv := range kvs {
+			// A later definition of the same key wins
+			fields = slices.DeleteFunc(fields, func(f outputField) bool { return f.key == kv[0] })
+			fields = append(fields, newOutputField(kv[0], kv[1]))
+		}
+	}
+	return fields, nil
+}
+
+// Collect the renames of the built-in keys from "rename_fields" in the config
+// file and --rename_field
+func getFieldRenames(v *viper.Viper) (map[string]string, error) {
+	renames := map[string]string{}
+	for _, key := range []string{"rename_fields", "rename_field"} {
+		kvs, err := getKeyValues(v, key)
+		if err != nil {
+			return nil, err
+		}
+		for _, kv := range kvs {
+			if !slices.Contains(renameableFields, kv[0]) {
+				return nil, fmt.Errorf("%v: '%v' must be one of: %v", key, kv[0], renameableFields)
+			}
+			if kv[1] == "" {
+				return nil, fmt.Errorf("%v: '%v' can't be renamed to nothing", key, kv[0])
+			}
+			renames[kv[0]] = kv[1]
+		}
+	}
+	return renames, nil
+}
+
+// Interpolate the fields for one line of a stream. Returns them as slog
+// key/value args
+func interpolateFields(args viperArgs, ctx interpolateContext) ([]any, error) {
+	var errReturn error
+	attrs := make([]any, 0, len(args.fields))
+	for _, f := range args.fields {
+		v := f.val
+		var err error
+		if f.template {
+			if v, err = renderTemplate(v, ctx); err != nil && errReturn == nil {
+				errReturn = err
+			}
+		}
+		if v, err = interpolate(args.interpolations, args.interpolationsRe, v, ctx); err != nil && errReturn == nil {
+			errReturn = err
+		}
+		attrs = append(attrs, slog.Any(f.key, fieldValue(v)))
+	}
+	return attrs, errReturn
+}
+
+// The time of a line in the --time_format. The unix formats are numbers,
+// anything else is a layout like the timestamp interpolator takes
+func formatTime(t time.Time, format string) slog.Value {
+	switch format {
+	case "unix":
+		return slog.Int64Value(t.Unix())
+	case "unix_ms":
+		return slog.Int64Value(t.UnixMilli())
+	case "unix_nano":
+		return slog.Int64Value(t.UnixNano())
+	}
+	if layout, ok := timestampLayouts[format]; ok {
+		return slog.StringValue(t.Format(layout))
+	}
+	return slog.StringValue(t.Format(format))
+}
+
+// Names the custom levels, renames the built-in keys and formats the time.
+// Used as the ReplaceAttr of the structured and logfmt handlers
+func builtinReplaceAttr(renames map[string]string, timeFormat string) func(groups []string, a slog.Attr) slog.Attr {
+	return func(groups []string, a slog.Attr) slog.Attr {
+		if v, ok := a.Value.Any().(fieldValue); ok {
+			return slog.String(a.Key, string(v))
+		}
+		if len(groups) > 0 || !slices.Contains(renameableFields, a.Key) {
+			return a
+		}
+		if a.Key == slog.TimeKey && a.Value.Kind() == slog.KindTime && timeFormat != "" {
+			a.Value = formatTime(a.Value.Time(), timeFormat)
+		}
+		if l, ok := a.Value.Any().(slog.Level); ok && a.Key == slog.LevelKey {
+			a.Value = slog.StringValue(levelName(l))
+		}
+		if n, ok := renames[a.Key]; ok {
+			a.Key = n
+		}
+		return a
+	}
+}
@@ -0,0 +1,87 @@
+/*
+Copyright © 2023 Ben Orgil
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+func (ts *ExecTestSuite) TestFields() {
+	cmd, err := ts.ExecuteCmd([]string{"--stdout=o", "--repeat=2", "--repeat_interval=0", "--interpolate_val=5",
+		"--field=service=api", "--field=n=__I__", "--field=stream={{.Stream}}",
+		"--rename_field=msg=message", "--rename_field=time=@timestamp", "--time_format=unix_ms"})
+	ts.Require().NoError(err)
+
+	lines := strings.Split(strings.TrimSuffix(cmd.RawStdOut, "\n"), "\n")
+	ts.Require().Len(lines, 2)
+	for i, l := range lines {
+		m := map[string]any{}
+		ts.Require().NoError(json.Unmarshal([]byte(l), &m))
+		ts.Equal("o", m["message"])
+		ts.Equal("api", m["service"])
+		ts.Equal([]string{"5", "6"}[i], m["n"])
+		ts.Equal("stdout", m["stream"])
+		ts.InDelta(time.Now().UnixMilli(), m["@timestamp"], 5000)
+		ts.NotContains(m, "msg")
+		ts.NotContains(m, "time")
+	}
+
+	// Same renames for logfmt
+	cmd, err = ts.ExecuteCmd([]string{"--stdout=o", "--output_format=logfmt", "--field=a=b c",
+		"--rename_field=level=severity", "--time_format=unix"})
+	ts.Require().NoError(err)
+	kv, err := parseLogfmt(strings.TrimSuffix(cmd.RawStdOut, "\n"))
+	ts.Require().NoError(err)
+	ts.Equal("INFO", kv["severity"])
+	ts.Equal("b c", kv["a"])
+	ts.Equal("o", kv["msg"])
+	ts.NotContains(kv["time"], "T")
+
+	// Only the built-in key is renamed, not a field with the same name
+	cmd, err = ts.ExecuteCmd([]string{"--stdout=o", "--field=msg=mine", "--rename_field=msg=message"})
+	ts.Require().NoError(err)
+	m := map[string]any{}
+	ts.Require().NoError(json.Unmarshal([]byte(cmd.RawStdOut), &m))
+	ts.Equal("o", m["message"])
+	ts.Equal("mine", m["msg"])
+
+	_, err = ts.ExecuteCmd([]string{"--stdout=o", "--rename_field=nope=x"})
+	ts.ErrorAs(err, new(*paramSetValidationError))
+	// The fixed schema formats can't rename or format the time
+	_, err = ts.ExecuteCmd([]string{"--stdout=o", "--output_format=ecs", "--rename_field=msg=message"})
+	ts.ErrorAs(err, new(*paramSetValidationError))
+	_, err = ts.ExecuteCmd([]string{"--stdout=o", "--output_format=gelf", "--time_format=unix"})
+	ts.ErrorAs(err, new(*paramSetValidationError))
+	_, err = ts.ExecuteCmd([]string{"--stdout=o", "--field=a={{.Nope"})
+	ts.ErrorAs(err, new(*paramSetValidationError))
+}
+
+func (ts *ExecTestSuite) TestFieldsConfig() {
+	config := `
+fields:
+  service: api
+  env: prod
+rename_fields:
+  msg: message
+`
+	cmd, err := ts.ExecuteCmdWithConfig(config, []string{"--stdout=o", "--field=env=dev"})
+	ts.Require().NoError(err)
+	// Config map fields are sorted, --field wins over the config
+	ts.Equal(`"message":"o","service":"api","env":"dev"}`, cmd.RawStdOut[strings.Index(cmd.RawStdOut, `"message"`):len(cmd.RawStdOut)-1])
+}
@@ -0,0 +1,241 @@
+/*
+Copyright © 2023 Ben Orgil
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// How often a followed file is checked for new lines
+const fileFollowPoll = 100 * time.Millisecond
+
+/*
+Streams a file line by line through the output loop instead of writing the
+same text over and over:
+
+	et --stdout_file=access.log --repeat_interval=0 --output_format=raw
+	et --stderr_file=app.log --file_follow --timeout=1m
+	et --stdout_file=captured.log --file_pacing --file_loop
+
+Each line is interpolated like --stdout text would be. The stream ends at the
+end of the file unless:
+
+  - file_loop: starts over from the top of the file
+  - file_follow: waits for lines to be appended, like 'tail -f'
+
+--repeat still caps the number of lines if it is set. With file_pacing the
+lines are written as far apart as the timestamps at their start (or the
+time field of JSON lines) are, instead of every repeat_interval. Lines
+without a timestamp, like the rest of a stack trace, follow right after the
+line before them.
+*/
+type fileStreamer struct {
+	file   *os.File
+	reader *bufio.Reader
+	loop   bool
+	follow bool
+	// Lines read since the file was last rewound, so an empty file isn't looped forever
+	read int
+	// The start of a line that was still being written when followed
+	partial string
+	// Line read ahead to work out the gap before it
+	pending    *string
+	pendingErr error
+	// Timestamp of the last written line that had one
+	last time.Time
+}
+
+func openFileStreamer(path string, args viperArgs) (*fileStreamer, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return &fileStreamer{
+		file:   f,
+		reader: bufio.NewReader(f),
+		loop:   args.fileLoop,
+		follow: args.fileFollow,
+	}, nil
+}
+
+func (f *fileStreamer) close() {
+	f.file.Close()
+}
+
+// Start reading from the top of the file again
+func (f *fileStreamer) rewind() error {
+	if _, err := f.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	f.reader.Reset(f.file)
+	f.partial = ""
+	f.read = 0
+	return nil
+}
+
+// Read the next line without its line ending. Returns io.EOF at the end of
+// the stream or the context's error if it's done while following the file
+func (f *fileStreamer) readLine(ctx context.Context) (string, error) {
+	for {
+		s, err := f.reader.ReadString('\n')
+		f.partial += s
+		if err == nil {
+			line := strings.TrimRight(f.partial, "\r\n")
+			f.partial = ""
+			f.read++
+			return line, nil
+		}
+		if err != io.EOF {
+			return "", err
+		}
+
+		switch {
+		case f.follow:
+			// A truncated file (ie rotated by copytruncate) is followed from the top
+			if info, err := f.file.Stat(); err == nil {
+				if pos, err := f.file.Seek(0, io.SeekCurrent); err == nil && info.Size() < pos {
+					if err := f.rewind(); err != nil {
+						return "", err
+					}
+				}
+			}
+			timer := time.NewTimer(fileFollowPoll)
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				return "", ctx.Err()
+			}
+		case f.partial != "":
+			// The last line doesn't end with a newline
+			line := f.partial
+			f.partial = ""
+			f.read++
+			return line, nil
+		case f.loop && f.read > 0:
+			if err := f.rewind(); err != nil {
+				return "", err
+			}
+		default:
+			return "", io.EOF
+		}
+	}
+}
+
+// The next line to write
+func (f *fileStreamer) next(ctx context.Context) (string, error) {
+	var line string
+	var err error
+	if f.pending != nil {
+		line, err = *f.pending, f.pendingErr
+		f.pending, f.pendingErr = nil, nil
+	} else {
+		line, err = f.readLine(ctx)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if t, ok := lineTimestamp(line); ok {
+		f.last = t
+	}
+	return line, nil
+}
+
+// Read the next line ahead without taking it
+func (f *fileStreamer) peek(ctx context.Context) (string, error) {
+	if f.pending == nil {
+		line, err := f.readLine(ctx)
+		f.pending, f.pendingErr = &line, err
+	}
+	return *f.pending, f.pendingErr
+}
+
+// Time until the next line for file_pacing. Reads the next line ahead to
+// compare its timestamp to the last one
+func (f *fileStreamer) gap(ctx context.Context) time.Duration {
+	line, err := f.peek(ctx)
+	if err != nil || f.last.IsZero() {
+		return 0
+	}
+	t, ok := lineTimestamp(line)
+	if !ok {
+		return 0
+	}
+	// Looping back to the top of the file goes back in time
+	return max(t.Sub(f.last), 0)
+}
+
+// Layouts tried on the start of a line for file_pacing
+var lineTimestampLayouts = []string{time.RFC3339Nano, "2006-01-02 15:04:05.999999999"}
+
+// Field names tried on JSON lines for file_pacing
+var lineTimestampFields = []string{"time", "@timestamp", "timestamp", "ts"}
+
+// Find the timestamp a line starts with, or the time field of a JSON line
+func lineTimestamp(line string) (time.Time, bool) {
+	if strings.HasPrefix(line, "{") {
+		m := map[string]any{}
+		if err := json.Unmarshal([]byte(line), &m); err != nil {
+			return time.Time{}, false
+		}
+		for _, k := range lineTimestampFields {
+			switch v := m[k].(type) {
+			case string:
+				if t, err := time.Parse(time.RFC3339Nano, v); err == nil {
+					return t, true
+				}
+				if f, err := strconv.ParseFloat(v, 64); err == nil {
+					return unixTimestamp(f), true
+				}
+			case float64:
+				return unixTimestamp(v), true
+			}
+		}
+		return time.Time{}, false
+	}
+
+	line = strings.TrimLeft(line, "[")
+	fields := strings.SplitN(line, " ", 3)
+	candidates := []string{strings.TrimRight(fields[0], "]")}
+	if len(fields) > 1 {
+		candidates = append(candidates, strings.TrimRight(fields[0]+" "+fields[1], "]"))
+	}
+	for _, c := range candidates {
+		for _, layout := range lineTimestampLayouts {
+			if t, err := time.Parse(layout, c); err == nil {
+				return t, true
+			}
+		}
+	}
+	return time.Time{}, false
+}
+
+// Unix timestamps in seconds or milliseconds
+func unixTimestamp(v float64) time.Time {
+	if v > 1e12 {
+		return time.UnixMilli(int64(v))
+	}
+	return time.Unix(0, int64(v*float64(time.Second)))
+}
@@ -0,0 +1,120 @@
+/*
+Copyright © 2023 Ben Orgil
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+)
+
+func (ts *ExecTestSuite) TestFileStream() {
+	file := filepath.Join(ts.T().TempDir(), "app.log")
+	ts.Require().NoError(os.WriteFile(file, []byte("a __I__\r\nb __I__\n\nc __I__"), 0644))
+
+	cmd, err := ts.ExecuteCmd([]string{"--stdout_file=" + file, "--repeat_interval=0", "--output_format=raw"})
+	ts.Require().NoError(err)
+	ts.Equal("a 0\nb 1\n\nc 3\n", cmd.RawStdOut)
+
+	// Looping is capped by repeat
+	cmd, err = ts.ExecuteCmd([]string{"--stderr_file=" + file, "--file_loop", "--repeat=6", "--repeat_interval=0", "--output_format=raw"})
+	ts.Require().NoError(err)
+	ts.Equal("a 0\nb 1\n\nc 3\na 4\nb 5\n", cmd.RawStdErr)
+	ts.Empty(cmd.RawStdOut)
+
+	// Doesn't wait for another tick after the last line
+	start := time.Now()
+	_, err = ts.ExecuteCmd([]string{"--stdout_file=" + file, "--repeat_interval=500ms", "--output_format=raw"})
+	ts.Require().NoError(err)
+	ts.Less(time.Since(start), 1900*time.Millisecond)
+}
+
+func (ts *ExecTestSuite) TestFileStreamFollow() {
+	file := filepath.Join(ts.T().TempDir(), "app.log")
+	ts.Require().NoError(os.WriteFile(file, []byte("first\n"), 0644))
+
+	go func() {
+		time.Sleep(300 * time.Millisecond)
+		f, err := os.OpenFile(file, os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			return
+		}
+		defer f.Close()
+		f.WriteString("sec")
+		time.Sleep(200 * time.Millisecond)
+		f.WriteString("ond\nthird\n")
+	}()
+
+	cmd, err := ts.ExecuteCmd([]string{"--stdout_file=" + file, "--file_follow", "--repeat_interval=0", "--timeout=1s", "--output_format=raw"})
+	ts.Require().NoError(err)
+	ts.Equal("first\nsecond\nthird\n", cmd.RawStdOut)
+}
+
+func (ts *ExecTestSuite) TestFileStreamPacing() {
+	file := filepath.Join(ts.T().TempDir(), "app.log")
+	lines := "2023-10-01T12:00:00Z one\n" +
+		"  at some.frame\n" +
+		"2023-10-01T12:00:00.3Z two\n" +
+		`{"time":"2023-10-01T12:00:00.5Z","msg":"three"}` + "\n"
+	ts.Require().NoError(os.WriteFile(file, []byte(lines), 0644))
+
+	start := time.Now()
+	cmd, err := ts.ExecuteCmd([]string{"--stdout_file=" + file, "--file_pacing", "--repeat_interval=10s", "--output_format=raw"})
+	ts.Require().NoError(err)
+	ts.Equal(lines, cmd.RawStdOut)
+	// Paced by the timestamps, not by repeat_interval
+	took := time.Since(start)
+	ts.GreaterOrEqual(took, 500*time.Millisecond)
+	ts.Less(took, 2*time.Second)
+}
+
+func (ts *ExecTestSuite) TestLineTimestamp() {
+	for line, want := range map[string]string{
+		"2023-10-01T12:00:00.25Z GET /":            "2023-10-01T12:00:00.25Z",
+		"[2023-10-01 12:00:01.5] INFO started":     "2023-10-01T12:00:01.5Z",
+		"2023-10-01 12:00:02 INFO started":         "2023-10-01T12:00:02Z",
+		`{"@timestamp":"2023-10-01T12:00:03Z"}`:    "2023-10-01T12:00:03Z",
+		`{"ts":1696161604.5,"msg":"started"}`:      "2023-10-01T12:00:04.5Z",
+		`{"timestamp":1696161605000,"msg":"done"}`: "2023-10-01T12:00:05Z",
+	} {
+		t, ok := lineTimestamp(line)
+		ts.Require().True(ok, line)
+		w, _ := time.Parse(time.RFC3339Nano, want)
+		ts.True(w.Equal(t), line)
+	}
+
+	for _, line := range []string{"", "GET / 200", `{"msg":"no time"}`, "{broken"} {
+		_, ok := lineTimestamp(line)
+		ts.False(ok, line)
+	}
+}
+
+func (ts *ExecTestSuite) TestFileStreamValidation() {
+	for _, tc := range []struct {
+		name string
+		args []string
+	}{
+		{"missing file", []string{"--stdout_file=/does/not/exist"}},
+		{"socket file without a socket", []string{"--socket_send_file=/does/not/exist"}},
+		{"loop and follow", []string{"--stdout_file=" + filepath.Join(ts.T().TempDir()), "--file_loop", "--file_follow"}},
+	} {
+		ts.Run(tc.name, func() {
+			_, err := ts.ExecuteCmd(tc.args)
+			ts.ErrorAs(err, new(*paramSetValidationError), tc.args)
+		})
+	}
+}
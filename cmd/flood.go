@@ -0,0 +1,143 @@
+/*
+Copyright © 2023 Ben Orgil
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+/*
+Tests how a process runner deals with full pipes. A classic bug is reading
+stdout to the end before reading stderr: once the stderr pipe's buffer
+(64KiB on Linux) is full the child blocks writing to it and never closes
+stdout, so both sides wait on each other forever.
+
+	et --flood_stream=stderr --flood_bytes=1MiB --stdout='done'
+
+writes 1MiB to stderr before anything else is written, then the usual
+output. Every write is timed. The time writes spent blocked is logged when
+the flood is done and when each stream is done, and a warning is logged as
+soon as a write has been blocked for flood_warn_after (the log goes to
+stdout, so a runner stuck reading stdout still sees it).
+*/
+const floodBlockedAfter = time.Millisecond
+
+// Times the writes to a stream
+type writeTimer struct {
+	writes int
+	bytes  int
+	// Writes that took longer than floodBlockedAfter and the time they took
+	blocked     int
+	blockedTime time.Duration
+	longest     time.Duration
+}
+
+// Time a write. Warns if it's been blocked for warnAfter and is still going.
+// A nil writeTimer just writes. Returns the number of bytes written
+func (t *writeTimer) time(logger *slog.Logger, stream string, warnAfter time.Duration, write func() int) int {
+	if t == nil {
+		return write()
+	}
+	start := time.Now()
+	var watchdog *time.Timer
+	if warnAfter > 0 {
+		watchdog = time.AfterFunc(warnAfter, func() {
+			logger.Warn(fmt.Sprintf("A write to '%v' has been blocked for '%v'. Is anything reading it?", stream, warnAfter))
+		})
+	}
+
+	n := write()
+
+	d := time.Since(start)
+	if watchdog != nil {
+		watchdog.Stop()
+	}
+	t.writes++
+	t.bytes += n
+	t.longest = max(t.longest, d)
+	if d >= floodBlockedAfter {
+		t.blocked++
+		t.blockedTime += d
+	}
+	return n
+}
+
+func (t *writeTimer) report(logger *slog.Logger, what string) {
+	logger.Info(fmt.Sprintf("%v: '%v' bytes in '%v' writes. '%v' writes blocked for '%v' in total, the longest write took '%v'",
+		what, t.bytes, t.writes, t.blocked, t.blockedTime, t.longest))
+}
+
+// Parse a size like "1MiB", "64KB" or "4096". Bare numbers are bytes
+func parseSize(s string) (int, error) {
+	units := []struct {
+		suffix string
+		size   int
+	}{
+		{"KiB", 1 << 10}, {"MiB", 1 << 20}, {"GiB", 1 << 30},
+		{"KB", 1000}, {"MB", 1000 * 1000}, {"GB", 1000 * 1000 * 1000},
+		{"K", 1 << 10}, {"M", 1 << 20}, {"G", 1 << 30}, {"B", 1},
+	}
+	s = strings.TrimSpace(s)
+	for _, u := range units {
+		if n, ok := strings.CutSuffix(s, u.suffix); ok {
+			v, err := strconv.ParseFloat(strings.TrimSpace(n), 64)
+			if err != nil {
+				break
+			}
+			return int(v * float64(u.size)), nil
+		}
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("'%v' is not a size like '1MiB', '64KB' or a number of bytes", s)
+	}
+	return v, nil
+}
+
+// Write flood_bytes to flood_stream in lines of about flood_chunk bytes. Stops
+// early if a write fails or ctx is done
+func flood(ctx context.Context, cmd *cobra.Command, args viperArgs) {
+	args = args.forStream(args.floodStream)
+	logger := args.outputFormatter
+
+	// The formatting adds to the line so it's only about chunk bytes
+	filler := strings.Repeat(".", max(args.floodChunk-1, 0))
+	t := &writeTimer{}
+	for n := 0; t.bytes < args.floodBytes && ctx.Err() == nil; n++ {
+		text := filler
+		if prefix := fmt.Sprintf("flood %v ", n); len(prefix) < len(filler) {
+			text = prefix + filler[len(prefix):]
+		}
+		written := t.time(logger.Logger, args.floodStream, args.floodWarnAfter, func() int {
+			return emit(ctx, cmd, args, args.floodStream, text, args.level)
+		})
+		// Every line has at least its terminator so nothing written means the
+		// write failed, ie the reader is gone. Retrying would spin forever
+		if written == 0 {
+			logger.Logger.Warn(fmt.Sprintf("A write to '%v' failed. Stopping the flood", args.floodStream))
+			break
+		}
+	}
+	t.report(logger.Logger, fmt.Sprintf("Flooded '%v'", args.floodStream))
+}
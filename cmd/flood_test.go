@@ -0,0 +1,115 @@
+/*
+Copyright © 2023 Ben Orgil
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/benorgil/exectester/configs"
+)
+
+func (ts *ExecTestSuite) TestParseSize() {
+	for s, want := range map[string]int{"4096": 4096, "1MiB": 1 << 20, "64KiB": 64 << 10, "64KB": 64000,
+		"1.5K": 1536, "2M": 2 << 20, "10B": 10, " 1 GiB ": 1 << 30} {
+		n, err := parseSize(s)
+		ts.NoError(err, s)
+		ts.Equal(want, n, s)
+	}
+	for _, s := range []string{"", "MiB", "1TiB", "lots"} {
+		_, err := parseSize(s)
+		ts.Error(err, s)
+	}
+}
+
+func (ts *ExecTestSuite) TestFlood() {
+	merged := ts.executeMerged([]string{"--flood_stream=stderr", "--flood_bytes=10KiB", "--flood_chunk=1KiB",
+		"--stdout=done", "--output_format=raw"})
+	lines := strings.Split(strings.TrimSuffix(merged, "\n"), "\n")
+	ts.Equal(11, len(lines))
+	ts.True(strings.HasPrefix(lines[0], "flood 0 ..."))
+	ts.Equal(1024, len(lines[0])+1)
+	// Nothing else is written until the flood is done
+	ts.Equal("done", lines[len(lines)-1])
+
+	// The flood is enough on its own
+	cmd, err := ts.ExecuteCmd([]string{"--flood_stream=stdout", "--flood_bytes=100", "--output_format=raw"})
+	ts.Require().NoError(err)
+	ts.GreaterOrEqual(len(cmd.RawStdOut), 100)
+}
+
+func (ts *ExecTestSuite) TestFloodBlocked() {
+	testArgExePath, present := os.LookupEnv(configs.TestArgExePath)
+	ts.Require().True(present, exe_err)
+
+	// Nobody reads stderr for a while, so the flood fills the pipe and blocks
+	var stdout bytes.Buffer
+	cmd := exec.Command(testArgExePath, "--flood_stream=stderr", "--flood_bytes=1MiB", "--flood_warn_after=100ms", "--stdout=done")
+	cmd.Stdout = &stdout
+	stderr, err := cmd.StderrPipe()
+	ts.Require().NoError(err)
+	ts.Require().NoError(cmd.Start())
+	time.Sleep(500 * time.Millisecond)
+	io.Copy(io.Discard, stderr)
+	ts.Require().NoError(cmd.Wait())
+
+	ts.Contains(stdout.String(), "A write to 'stderr' has been blocked for '100ms'")
+	ts.Regexp(`Flooded 'stderr': '\d+' bytes in '\d+' writes. '[1-9]\d*' writes blocked`, stdout.String())
+	ts.Contains(stdout.String(), `"msg":"done"`)
+}
+
+func (ts *ExecTestSuite) TestFloodClosedStream() {
+	// The reader is gone so every write fails without writing anything
+	r, w := io.Pipe()
+	r.Close()
+	o := bytes.NewBufferString("")
+	cmd := RootCmd(configs.FallbackLogger)
+	cmd.SetOut(o)
+	cmd.SetErr(w)
+	cmd.SetArgs([]string{"--flood_stream=stderr", "--flood_bytes=1MiB", "--stdout=done"})
+
+	done := make(chan error, 1)
+	go func() { done <- cmd.Execute() }()
+	select {
+	case err := <-done:
+		ts.NoError(err)
+	case <-time.After(10 * time.Second):
+		ts.FailNow("the flood is still writing to a closed stream")
+	}
+	ts.Contains(o.String(), `"msg":"done"`)
+}
+
+func (ts *ExecTestSuite) TestFloodValidation() {
+	for _, tc := range []struct {
+		name string
+		args []string
+	}{
+		{"socket flood stream", []string{"--flood_stream=socket"}},
+		{"flood_bytes not a size", []string{"--flood_stream=stdout", "--flood_bytes=lots"}},
+		{"zero flood_chunk", []string{"--flood_stream=stdout", "--flood_chunk=0"}},
+		{"flood_warn_after not a duration", []string{"--flood_stream=stdout", "--flood_warn_after=never"}},
+	} {
+		ts.Run(tc.name, func() {
+			_, err := ts.ExecuteCmd(tc.args)
+			ts.ErrorAs(err, new(*paramSetValidationError), tc.args)
+		})
+	}
+}
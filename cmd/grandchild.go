@@ -0,0 +1,132 @@
+/*
+Copyright © 2023 Ben Orgil
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime/debug"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+/*
+A process that exits while something it spawned still holds its stdout and
+stderr leaves whoever reads them waiting for an EOF that doesn't come until
+the grandchild is gone too. It's why exec.Cmd has a WaitDelay. With
+
+	et --stdout='done' --exitcode=3 --grandchild_hold=30s
+
+et starts a detached copy of itself, in its own session so signals sent to
+et's process group don't reach it, that inherits stdout and stderr and
+holds them for 30s after et has exited with code 3. With --grandchild_text
+it also writes that text, with its pid and a counter, to both every
+repeat_interval while it holds them.
+
+The copy is the running executable. When et is embedded in another program,
+ie a test binary, that program has no grandchild subcommand so the path of
+an et binary has to be given with --grandchild_exe.
+*/
+func spawnGrandchild(args viperArgs) (int, error) {
+	c := exec.Command(args.grandchildExe, "grandchild",
+		"--hold="+args.grandchildHold.String(),
+		"--text="+args.grandchildText,
+		"--interval="+args.repeatInterval.String())
+	// The real files, not cmd.OutOrStdout(), so they're inherited as is
+	c.Stdout = os.Stdout
+	c.Stderr = os.Stderr
+	detach(c)
+	if err := c.Start(); err != nil {
+		return 0, err
+	}
+	pid := c.Process.Pid
+	// Never waited for, it's meant to outlive us
+	c.Process.Release()
+	return pid, nil
+}
+
+// The main package of the et binary
+const etMainPackage = "github.com/benorgil/exectester"
+
+// The et binary started by spawnGrandchild(): exe if it's set, else the
+// running executable if it's et
+func grandchildExe(exe string) (string, error) {
+	if exe != "" {
+		return exe, nil
+	}
+	if bi, ok := debug.ReadBuildInfo(); !ok || bi.Path != etMainPackage {
+		return "", fmt.Errorf("grandchild_hold needs grandchild_exe when et is embedded in another program")
+	}
+	return os.Executable()
+}
+
+// Hold the inherited stdout and stderr, writing to them if there is text
+func grandchild(cmd *cobra.Command, v *viper.Viper) error {
+	hold, err := parseDuration(v.GetString("grandchild.hold"))
+	if err != nil {
+		return &paramSetValidationError{fmt.Sprintf("hold: %v", err.Error())}
+	}
+	interval, err := parseDuration(v.GetString("grandchild.interval"))
+	if err != nil {
+		return &paramSetValidationError{fmt.Sprintf("interval: %v", err.Error())}
+	}
+	text := v.GetString("grandchild.text")
+
+	ctx, cancel := context.WithTimeout(context.Background(), hold)
+	defer cancel()
+	if text == "" {
+		<-ctx.Done()
+		return nil
+	}
+
+	sched := newScheduler(interval)
+	for n := 0; ; n++ {
+		line := fmt.Sprintf("%v pid=%v n=%v\n", text, os.Getpid(), n)
+		fmt.Fprint(cmd.OutOrStdout(), line)
+		fmt.Fprint(cmd.ErrOrStderr(), line)
+		if !sched.wait(ctx) {
+			return nil
+		}
+	}
+}
+
+func grandchildCmd(v *viper.Viper) *cobra.Command {
+	grandchildCmd := &cobra.Command{
+		Use:   "grandchild",
+		Short: "Hold stdout and stderr open. Started by --grandchild_hold",
+		// Only started by et itself
+		Hidden: true,
+		Args:   cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return validationExitError(grandchild(cmd, v))
+		},
+	}
+
+	grandchildCmd.Flags().String("hold", "0", "How long to hold stdout and stderr")
+	v.BindPFlag("grandchild.hold", grandchildCmd.Flags().Lookup("hold"))
+
+	grandchildCmd.Flags().String("text", "", "Text to write to stdout and stderr while holding them")
+	v.BindPFlag("grandchild.text", grandchildCmd.Flags().Lookup("text"))
+
+	grandchildCmd.Flags().String("interval", "1s", "Time between the writes of the text")
+	v.BindPFlag("grandchild.interval", grandchildCmd.Flags().Lookup("interval"))
+
+	return grandchildCmd
+}
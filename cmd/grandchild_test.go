@@ -0,0 +1,75 @@
+/*
+Copyright © 2023 Ben Orgil
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/benorgil/exectester/configs"
+)
+
+func (ts *ExecTestSuite) TestGrandchildHoldsPipes() {
+	testArgExePath, present := os.LookupEnv(configs.TestArgExePath)
+	ts.Require().True(present, exe_err)
+
+	// Wait() doesn't return until the grandchild lets go of the pipes
+	var stdout, stderr bytes.Buffer
+	c := exec.Command(testArgExePath, "--stdout=done", "--exitcode=3", "--grandchild_hold=1s",
+		"--grandchild_text=still here", "--repeat_interval=200ms")
+	c.Stdout, c.Stderr = &stdout, &stderr
+	start := time.Now()
+	err := c.Run()
+	ts.GreaterOrEqual(time.Since(start), time.Second)
+	ts.Equal(3, c.ProcessState.ExitCode())
+	var exitErr *exec.ExitError
+	ts.True(errors.As(err, &exitErr))
+
+	ts.Contains(stdout.String(), "Started grandchild")
+	ts.Contains(stdout.String(), `"msg":"done"`)
+	ts.GreaterOrEqual(strings.Count(stdout.String(), "still here pid="), 4)
+	ts.GreaterOrEqual(strings.Count(stderr.String(), "still here pid="), 4)
+
+	// Unless the caller gives up on them
+	c = exec.Command(testArgExePath, "--stdout=done", "--grandchild_hold=2s")
+	c.Stdout = &stdout
+	c.WaitDelay = 100 * time.Millisecond
+	start = time.Now()
+	err = c.Run()
+	ts.ErrorIs(err, exec.ErrWaitDelay)
+	ts.Less(time.Since(start), time.Second)
+
+	// Embedded in the test binary et has to be told where it is
+	_, err = ts.ExecuteCmd([]string{"--stdout=o", "--grandchild_hold=100ms", "--grandchild_exe=" + testArgExePath})
+	ts.NoError(err)
+}
+
+func (ts *ExecTestSuite) TestGrandchildValidation() {
+	_, err := ts.ExecuteCmd([]string{"--stdout=o", "--grandchild_hold=-1s"})
+	ts.ErrorAs(err, new(*paramSetValidationError))
+
+	_, err = ts.ExecuteCmd([]string{"--stdout=o", "--grandchild_hold=forever"})
+	ts.ErrorAs(err, new(*paramSetValidationError))
+
+	// The test binary isn't et
+	_, err = ts.ExecuteCmd([]string{"--stdout=o", "--grandchild_hold=1s"})
+	ts.ErrorAs(err, new(*paramSetValidationError))
+}
@@ -0,0 +1,170 @@
+/*
+Copyright © 2023 Ben Orgil
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+const interleaveRandom = "random"
+
+/*
+Normally stdout and stderr are written by their own goroutines so the order
+of their lines relative to each other is up to the Go scheduler. With
+--interleave both are written by one loop, one line per repeat_interval, in
+the order of a pattern that is repeated until the streams are done:
+
+	et --stdout='o __I__' --stderr='e __I__' --repeat=4 --repeat_interval=0 --interleave=o,e,e,o
+
+'random' picks the stream of every line at random, reproducibly with --seed.
+Once a stream has written its repeat lines it's skipped in the pattern.
+
+With --interleave_seq every line also gets a "gseq" number counting the lines
+of both streams together, so whatever merges them can be checked for order.
+It's a field of the structured formats and a ' gseq=N' suffix of raw and
+human_readable lines.
+
+The per stream rate, timing and timeout settings don't apply since the
+streams share the global repeat_interval and timeout. The socket, and a
+stream left out of the pattern, are still written on their own.
+*/
+var interleaveStreams = map[string]string{"o": "stdout", "stdout": "stdout", "e": "stderr", "stderr": "stderr"}
+
+// Parse an --interleave pattern into the streams it names
+func parseInterleave(pattern string) ([]string, error) {
+	if pattern == interleaveRandom {
+		return []string{interleaveRandom}, nil
+	}
+	var streams []string
+	for _, p := range strings.Split(pattern, ",") {
+		stream, ok := interleaveStreams[strings.TrimSpace(p)]
+		if !ok {
+			return nil, fmt.Errorf("interleave '%v' must be 'random' or a list of 'o' and 'e' like 'o,e,e,o'", pattern)
+		}
+		streams = append(streams, stream)
+	}
+	return streams, nil
+}
+
+// Whether a stream is written by the --interleave loop instead of on its own
+func (args viperArgs) interleaved(stream string) bool {
+	if len(args.interleave) == 0 || stream == "socket" {
+		return false
+	}
+	return args.interleave[0] == interleaveRandom || slices.Contains(args.interleave, stream)
+}
+
+// Numbers the lines of every stream together for --interleave_seq
+type globalSequence struct {
+	n int
+	// Formats without fields get it as a suffix of the text
+	suffix bool
+}
+
+func newGlobalSequence(args viperArgs) *globalSequence {
+	format := outputFormatterEnum(args.outputFormat)
+	return &globalSequence{suffix: format == outputFormatterEnumRaw || format == outputFormatterEnumHuman}
+}
+
+// Number the next line. Does nothing on a nil globalSequence
+func (g *globalSequence) apply(text string, fields []any) (string, []any) {
+	if g == nil {
+		return text, fields
+	}
+	g.n++
+	if g.suffix {
+		return fmt.Sprintf("%v gseq=%v", text, g.n), fields
+	}
+	return text, append(fields, slog.Int("gseq", g.n))
+}
+
+// Write stdout and stderr from one loop in the order of the --interleave
+// pattern until they're done or ctx is cancelled. Returns whether the timeout
+// was reached
+func outputInterleaved(ctx context.Context, cmd *cobra.Command, args viperArgs) bool {
+	logger := args.outputFormatter
+
+	var global *globalSequence
+	if args.interleaveSeq {
+		global = newGlobalSequence(args)
+	}
+	writers := map[string]*streamWriter{}
+	for _, stream := range []string{"stdout", "stderr"} {
+		if !args.streamUsed(stream) || !args.interleaved(stream) {
+			continue
+		}
+		w, err := newStreamWriter(cmd, args.forStream(stream), stream)
+		if err != nil {
+			logger.Logger.Error(err.Error())
+			continue
+		}
+		defer w.close()
+		w.global = global
+		writers[stream] = w
+	}
+
+	if args.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, args.timeout)
+		defer cancel()
+	}
+	sched := newScheduler(args.repeatInterval)
+	rand := newStreamRand(args.seed, "interleave")
+
+	for i := 0; len(writers) > 0; i++ {
+		var stream string
+		if args.interleave[0] == interleaveRandom {
+			// Map order is random but not seeded
+			active := make([]string, 0, len(writers))
+			for _, s := range []string{"stdout", "stderr"} {
+				if _, ok := writers[s]; ok {
+					active = append(active, s)
+				}
+			}
+			stream = active[rand.Intn(len(active))]
+		} else {
+			stream = args.interleave[i%len(args.interleave)]
+		}
+
+		// Streams that are done, or not written to at all, are skipped
+		w, ok := writers[stream]
+		if !ok {
+			continue
+		}
+		if _, done := w.next(ctx); done {
+			delete(writers, stream)
+		}
+
+		if len(writers) > 0 && !sched.wait(ctx) {
+			if ctx.Err() == context.DeadlineExceeded {
+				logger.Logger.Info(fmt.Sprintf("Timeout of '%v' was reached", args.timeout))
+			}
+			break
+		}
+	}
+
+	if sched.missed > 0 {
+		logger.Logger.Warn(fmt.Sprintf("Missed '%v' ticks on 'interleave' because writes blocked", sched.missed))
+	}
+	return ctx.Err() == context.DeadlineExceeded
+}
@@ -0,0 +1,69 @@
+/*
+Copyright © 2023 Ben Orgil
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/benorgil/exectester/configs"
+)
+
+// Run the root command with stdout and stderr going to the same buffer
+func (ts *ExecTestSuite) executeMerged(args []string) string {
+	var b bytes.Buffer
+	cmd := RootCmd(configs.FallbackLogger)
+	cmd.SetOut(&b)
+	cmd.SetErr(&b)
+	cmd.SetArgs(args)
+	ts.Require().NoError(cmd.Execute())
+	return b.String()
+}
+
+func (ts *ExecTestSuite) TestInterleavePattern() {
+	merged := ts.executeMerged([]string{"--stdout=o__I__", "--stderr=e__I__", "--repeat=4", "--repeat_interval=0",
+		"--interleave=o,e,e", "--interleave_seq", "--output_format=raw"})
+	ts.Equal("o0 gseq=1\ne0 gseq=2\ne1 gseq=3\no1 gseq=4\ne2 gseq=5\ne3 gseq=6\no2 gseq=7\no3 gseq=8\n", merged)
+
+	// The gseq is a field of the structured formats
+	cmd, err := ts.ExecuteCmd([]string{"--stdout=o", "--stderr=e", "--repeat=2", "--repeat_interval=0",
+		"--interleave=stderr,stdout", "--interleave_seq", "--output_format=logfmt"})
+	ts.Require().NoError(err)
+	ts.Contains(cmd.RawStdErr, "msg=e gseq=1\n")
+	ts.Contains(cmd.RawStdOut, "msg=o gseq=2\n")
+	ts.Contains(cmd.RawStdErr, "msg=e gseq=3\n")
+}
+
+func (ts *ExecTestSuite) TestInterleaveRandom() {
+	args := []string{"--stdout=o", "--stderr=e", "--repeat=50", "--repeat_interval=0", "--interleave=random",
+		"--output_format=raw", "--seed=3"}
+	merged := ts.executeMerged(args)
+	ts.Equal(50, strings.Count(merged, "o\n"))
+	ts.Equal(50, strings.Count(merged, "e\n"))
+	ts.NotEqual(strings.Repeat("o\ne\n", 50), merged)
+
+	// The same seed gives the same order
+	ts.Equal(merged, ts.executeMerged(args))
+}
+
+func (ts *ExecTestSuite) TestInterleaveValidation() {
+	_, err := ts.ExecuteCmd([]string{"--stdout=o", "--interleave=o,x"})
+	ts.ErrorAs(err, new(*paramSetValidationError))
+
+	_, err = ts.ExecuteCmdWithConfig("interleave: o,e\nscenario: [{action: emit, stream: stdout, text: x}]", []string{})
+	ts.ErrorAs(err, new(*paramSetValidationError))
+}
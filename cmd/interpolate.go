@@ -0,0 +1,236 @@
+/*
+Copyright © 2023 Ben Orgil
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+/*
+A single interpolation key and how to replace it. They come from the
+original interpolate_key/interpolator/interpolate_val flags and from the
+repeatable --interpolate flag:
+
+	et --stdout='__TS__ n=__N__ host=__HOST__' --repeat=3 \
+		--interpolate=__N__=int_counter:100 \
+		--interpolate=__HOST__=string:web1 \
+		--interpolate=__TS__=timestamp:rfc3339
+
+or a map in the config file:
+
+	interpolate:
+	  __N__: int_counter:100
+	  __HOST__: string:web1
+*/
+type interpolation struct {
+	key          string
+	interpolator string
+	val          string
+	// Viper lowercases the keys of config maps, so keys from the config
+	// file have to be matched without case
+	caseInsensitive bool
+}
+
+// Named layouts for the timestamp interpolator. Anything else is used as a
+// Go time layout
+var timestampLayouts = map[string]string{
+	"":            time.RFC3339,
+	"rfc3339":     time.RFC3339,
+	"rfc3339nano": time.RFC3339Nano,
+	"rfc1123":     time.RFC1123,
+	"kitchen":     time.Kitchen,
+	"stamp":       time.Stamp,
+	"stampmilli":  time.StampMilli,
+	"datetime":    time.DateTime,
+}
+
+// Parse a "KEY=interpolator:value" definition. The value is optional
+func parseInterpolation(def string) (interpolation, error) {
+	key, rest, ok := strings.Cut(def, "=")
+	if !ok || key == "" {
+		return interpolation{}, fmt.Errorf("interpolate '%v' must look like KEY=interpolator:value", def)
+	}
+	interpolator, val, _ := strings.Cut(rest, ":")
+	if !slices.Contains(interpolatorEnumValues, interpolator) {
+		return interpolation{}, fmt.Errorf("interpolate '%v': interpolator %v", def, interpolatorEnumValuesErrMsg)
+	}
+	return interpolation{key: key, interpolator: interpolator, val: val}, nil
+}
+
+// Collect every interpolation definition. The --interpolate definitions come
+// first so they win over interpolate_key if both use the same key. The key
+// func decides which viper key each setting is read from (see streamKey())
+func getInterpolations(v *viper.Viper, key func(string) string) ([]interpolation, error) {
+	var defs []interpolation
+
+	switch val := v.Get(key("interpolate")).(type) {
+	case []string:
+		for _, d := range val {
+			i, err := parseInterpolation(d)
+			if err != nil {
+				return nil, err
+			}
+			defs = append(defs, i)
+		}
+	case []any:
+		for _, d := range val {
+			i, err := parseInterpolation(fmt.Sprint(d))
+			if err != nil {
+				return nil, err
+			}
+			defs = append(defs, i)
+		}
+	case map[string]any:
+		for k, d := range val {
+			i, err := parseInterpolation(k + "=" + fmt.Sprint(d))
+			if err != nil {
+				return nil, err
+			}
+			i.caseInsensitive = true
+			defs = append(defs, i)
+		}
+	}
+
+	// The template interpolator renders the whole text instead of a key
+	if interpolator := v.GetString(key("interpolator")); interpolator != string(interpolatorEnumTemplate) {
+		defs = append(defs, interpolation{
+			key:          v.GetString(key("interpolate_key")),
+			interpolator: interpolator,
+			val:          v.GetString(key("interpolate_val")),
+		})
+	}
+
+	return defs, nil
+}
+
+// Build one regex matching every key so all of them are replaced in a single
+// pass. Longer keys go first so "__ID__" isn't eaten by "__I"
+func interpolationsRegexp(defs []interpolation) *regexp.Regexp {
+	sorted := slices.Clone(defs)
+	sort.SliceStable(sorted, func(i, j int) bool { return len(sorted[i].key) > len(sorted[j].key) })
+
+	var alts []string
+	for _, d := range sorted {
+		if d.key == "" {
+			continue
+		}
+		if d.caseInsensitive {
+			alts = append(alts, "(?i:"+regexp.QuoteMeta(d.key)+")")
+		} else {
+			alts = append(alts, regexp.QuoteMeta(d.key))
+		}
+	}
+	if len(alts) == 0 {
+		return nil
+	}
+	return regexp.MustCompile(strings.Join(alts, "|"))
+}
+
+// Find which definition a matched key belongs to
+func findInterpolation(defs []interpolation, match string) (interpolation, bool) {
+	for _, d := range defs {
+		if d.key == match || (d.caseInsensitive && strings.EqualFold(d.key, match)) {
+			return d, true
+		}
+	}
+	return interpolation{}, false
+}
+
+// The value an interpolator produces for the current iteration
+func interpolateValue(interpolator string, val string, ctx interpolateContext) (string, error) {
+	switch i := interpolator; i {
+	case "int_counter":
+		v, err := strconv.Atoi(val)
+		if err != nil {
+			err = fmt.Errorf("'interpolate_val' of '%v' cannot be converted to a number! Defaulting to '0'", val)
+		}
+		return strconv.Itoa(v + ctx.Counter), err
+	case "string":
+		return val, nil
+	case "timestamp":
+		now := ctx.IterationStartTime
+		switch val {
+		case "unix":
+			return strconv.FormatInt(now.Unix(), 10), nil
+		case "unix_ms":
+			return strconv.FormatInt(now.UnixMilli(), 10), nil
+		case "unix_nano":
+			return strconv.FormatInt(now.UnixNano(), 10), nil
+		}
+		if layout, ok := timestampLayouts[val]; ok {
+			return now.Format(layout), nil
+		}
+		return now.Format(val), nil
+	case "template":
+		return renderTemplate(val, ctx)
+	case "preset":
+		return interpolatePreset(val, ctx)
+	case "data_set":
+		return ctx.Row[val], nil
+	default:
+		return strconv.Itoa(ctx.Counter), nil
+	}
+}
+
+// Replace every interpolation key found in outputText in one pass. Each key
+// gets a single value per iteration even if it shows up more than once
+func interpolate(defs []interpolation, re *regexp.Regexp, outputText string, ctx interpolateContext) (string, error) {
+	if re == nil {
+		return outputText, nil
+	}
+
+	var errReturn error
+	values := map[string]string{}
+	interpolated := re.ReplaceAllStringFunc(outputText, func(match string) string {
+		d, ok := findInterpolation(defs, match)
+		if !ok {
+			return match
+		}
+		if v, ok := values[d.key]; ok {
+			return v
+		}
+		v, err := interpolateValue(d.interpolator, d.val, ctx)
+		if err != nil && errReturn == nil {
+			errReturn = err
+		}
+		values[d.key] = v
+		return v
+	})
+
+	return interpolated, errReturn
+}
+
+// Interpolates the output text for one iteration of a stream. The template
+// interpolator renders the whole text first, then any keys are replaced
+func interpolateStream(args viperArgs, ctx interpolateContext, outputText string) (string, error) {
+	if args.interpolator == string(interpolatorEnumTemplate) {
+		rendered, err := renderTemplate(outputText, ctx)
+		if err != nil {
+			return rendered, err
+		}
+		outputText = rendered
+	}
+	return interpolate(args.interpolations, args.interpolationsRe, outputText, ctx)
+}
@@ -0,0 +1,159 @@
+/*
+Copyright © 2023 Ben Orgil
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"crypto/rand"
+	"fmt"
+	mathrand "math/rand"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+)
+
+/*
+With the "template" interpolator the output text is parsed as a Go
+text/template and rendered every iteration. For example:
+
+	et --interpolator=template --repeat=3 \
+		--stdout='{{now.Format "15:04:05"}} {{.Stream}}[{{.Pid}}] req={{uuid}} n={{pad 4 .Counter}}'
+
+The fields of interpolateContext are available in the template along with
+the functions in templateFuncs.
+*/
+type interpolateContext struct {
+	// Number of times the stream has been written to so far
+	Counter int
+	// The stream being written to: stdout, stderr or socket
+	Stream string
+	Pid    int
+	// Time since the stream started
+	Elapsed time.Duration
+	// When the stream started and when this iteration started
+	StartTime          time.Time
+	IterationStartTime time.Time
+	Env                map[string]string
+	// The line's row of the data_set, if there is one
+	Row dataSetRow
+	// Picks the values of the preset interpolator and randInt
+	rand *mathrand.Rand
+	// The configured templates, shared by every stream (see viperArgs)
+	parsed map[string]*template.Template
+	// The stream's copies of them, with randInt using rand
+	templates map[string]*template.Template
+}
+
+// Create the context for a stream. Call next() at the start of every iteration
+func newInterpolateContext(stream string, seed int64, parsed map[string]*template.Template) interpolateContext {
+	env := map[string]string{}
+	for _, e := range os.Environ() {
+		if k, v, ok := strings.Cut(e, "="); ok {
+			env[k] = v
+		}
+	}
+	now := time.Now()
+	return interpolateContext{
+		Stream:             stream,
+		Pid:                os.Getpid(),
+		StartTime:          now,
+		IterationStartTime: now,
+		Env:                env,
+		rand:               newStreamRand(seed, stream+"_preset"),
+		parsed:             parsed,
+		templates:          map[string]*template.Template{},
+	}
+}
+
+// Update the context for the given iteration
+func (c interpolateContext) next(counter int) interpolateContext {
+	c.Counter = counter
+	c.IterationStartTime = time.Now()
+	c.Elapsed = c.IterationStartTime.Sub(c.StartTime)
+	return c
+}
+
+// Random version 4 uuid. Not pulling in a dependency just for this
+func templateUUID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// Random int in [min, max) picked with intn
+func randInt(intn func(int) int, min int, max int) int {
+	if max <= min {
+		return min
+	}
+	return min + intn(max-min)
+}
+
+// Functions available in templates. The output text's templates get a randInt
+// that follows --seed, see renderTemplate()
+var templateFuncs = template.FuncMap{
+	"now":  time.Now,
+	"uuid": templateUUID,
+	"randInt": func(min int, max int) int {
+		return randInt(mathrand.Intn, min, max)
+	},
+	// Left pad with zeros to width
+	"pad": func(width int, v any) string {
+		return fmt.Sprintf("%0*v", width, v)
+	},
+	"env": os.Getenv,
+	"hostname": func() string {
+		h, _ := os.Hostname()
+		return h
+	},
+	"upper": strings.ToUpper,
+	"lower": strings.ToLower,
+}
+
+// Parse the output text as a template
+func parseOutputTemplate(outputText string) (*template.Template, error) {
+	return template.New("output").Funcs(templateFuncs).Option("missingkey=zero").Parse(outputText)
+}
+
+// Render the output text as a template with the iteration's context. Only the
+// configured texts are kept parsed. Every line of a file is different so they
+// are parsed each time instead of piling up over a long run
+func renderTemplate(outputText string, ctx interpolateContext) (string, error) {
+	t, ok := ctx.templates[outputText]
+	if !ok {
+		var err error
+		if parsed, configured := ctx.parsed[outputText]; configured {
+			// The parsed template is shared by every stream so randInt is
+			// swapped in a copy of it
+			if t, err = parsed.Clone(); err != nil {
+				return outputText, err
+			}
+			ctx.templates[outputText] = t
+		} else if t, err = parseOutputTemplate(outputText); err != nil {
+			return outputText, err
+		}
+		t.Funcs(template.FuncMap{"randInt": func(min int, max int) int {
+			return randInt(ctx.rand.Intn, min, max)
+		}})
+	}
+	var b strings.Builder
+	if err := t.Execute(&b, ctx); err != nil {
+		return outputText, err
+	}
+	return b.String(), nil
+}
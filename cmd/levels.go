@@ -0,0 +1,155 @@
+/*
+Copyright © 2023 Ben Orgil
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+/*
+The level every line of a stream is logged at. Either a single level:
+
+	et --stdout='o' --stderr='e' --level=info --stderr_level=error
+
+or a weighted distribution picked from at random (reproducible with --seed):
+
+	et --stdout='request __I__' --repeat_forever --level_distribution=info=80,warn=15,error=5
+
+Besides slog's debug, info, warn and error there are trace and fatal, and
+offsets like "error+2" work too.
+*/
+const (
+	levelTrace = slog.Level(-8)
+	levelFatal = slog.Level(12)
+	// Output lines are never filtered by level, only the app's own log messages are
+	levelAll = slog.Level(math.MinInt)
+)
+
+var customLevelNames = map[slog.Level]string{
+	levelTrace: "TRACE",
+	levelFatal: "FATAL",
+}
+
+// Name of a level, including the custom ones slog would call "DEBUG-4" or "ERROR+4"
+func levelName(l slog.Level) string {
+	if n, ok := customLevelNames[l]; ok {
+		return n
+	}
+	return l.String()
+}
+
+// Parse a level name like "warn", "FATAL" or "info+2"
+func parseLevel(s string) (slog.Level, error) {
+	for l, n := range customLevelNames {
+		if strings.EqualFold(s, n) {
+			return l, nil
+		}
+	}
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(s)); err != nil {
+		return l, fmt.Errorf("level '%v' must be one of: trace, debug, info, warn, error, fatal", s)
+	}
+	return l, nil
+}
+
+// A level and its share of a level_distribution
+type weightedLevel struct {
+	level  slog.Level
+	weight float64
+}
+
+// Read a level distribution from a "info=80,warn=15,error=5" string or a map
+// in the config file. The weights don't have to add up to 100
+func getLevelDistribution(v *viper.Viper, key string) ([]weightedLevel, error) {
+	var pairs [][2]string
+	switch val := v.Get(key).(type) {
+	case string:
+		if val == "" {
+			return nil, nil
+		}
+		for _, def := range strings.Split(val, ",") {
+			name, weight, ok := strings.Cut(strings.TrimSpace(def), "=")
+			if !ok {
+				return nil, fmt.Errorf("%v '%v' must look like level=weight,level=weight", key, val)
+			}
+			pairs = append(pairs, [2]string{name, weight})
+		}
+	case map[string]any:
+		for name, weight := range val {
+			pairs = append(pairs, [2]string{name, fmt.Sprint(weight)})
+		}
+	}
+
+	var dist []weightedLevel
+	for _, p := range pairs {
+		l, err := parseLevel(p[0])
+		if err != nil {
+			return nil, fmt.Errorf("%v: %v", key, err.Error())
+		}
+		w, err := strconv.ParseFloat(p[1], 64)
+		if err != nil || w < 0 {
+			return nil, fmt.Errorf("%v: weight '%v' of '%v' must be a positive number", key, p[1], p[0])
+		}
+		dist = append(dist, weightedLevel{l, w})
+	}
+	// Map order is random, keep picks reproducible with the seed
+	sort.Slice(dist, func(i, j int) bool { return dist[i].level < dist[j].level })
+	return dist, nil
+}
+
+// Picks the level of each line of a stream
+type levelPicker struct {
+	level slog.Level
+	dist  []weightedLevel
+	total float64
+	rand  *rand.Rand
+}
+
+func newLevelPicker(args viperArgs, stream string) *levelPicker {
+	p := &levelPicker{
+		level: args.level,
+		dist:  args.levelDistribution,
+		// Its own generator so picking levels doesn't change the rate mode's randomness
+		rand: newStreamRand(args.seed, stream+"_level"),
+	}
+	for _, w := range p.dist {
+		p.total += w.weight
+	}
+	return p
+}
+
+func (p *levelPicker) pick() slog.Level {
+	if p.total <= 0 {
+		return p.level
+	}
+	r := p.rand.Float64() * p.total
+	for _, w := range p.dist {
+		if r < w.weight {
+			return w.level
+		}
+		r -= w.weight
+	}
+	return p.dist[len(p.dist)-1].level
+}
@@ -0,0 +1,97 @@
+/*
+Copyright © 2023 Ben Orgil
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"encoding/json"
+	"log/slog"
+	"strings"
+)
+
+// The level of every line of structured output
+func outputLevels(ts *ExecTestSuite, out string) []string {
+	var levels []string
+	for _, l := range strings.Split(strings.TrimSuffix(out, "\n"), "\n") {
+		m := map[string]any{}
+		ts.Require().NoError(json.Unmarshal([]byte(l), &m))
+		levels = append(levels, m["level"].(string))
+	}
+	return levels
+}
+
+func (ts *ExecTestSuite) TestLevel() {
+	cmd, err := ts.ExecuteCmd([]string{"--stdout=o", "--stderr=e", "--level=trace", "--stderr_level=fatal"})
+	ts.Require().NoError(err)
+	ts.Equal([]string{"TRACE"}, outputLevels(ts, cmd.RawStdOut))
+	ts.Equal([]string{"FATAL"}, outputLevels(ts, cmd.RawStdErr))
+
+	cmd, err = ts.ExecuteCmd([]string{"--stdout=o", "--level=error+2", "--output_format=logfmt"})
+	ts.Require().NoError(err)
+	ts.Contains(cmd.RawStdOut, " level=ERROR+2 ")
+
+	_, err = ts.ExecuteCmd([]string{"--stdout=o", "--level=loud"})
+	ts.ErrorAs(err, new(*paramSetValidationError))
+}
+
+func (ts *ExecTestSuite) TestLevelDistribution() {
+	args := []string{"--stdout=o", "--repeat=1000", "--repeat_interval=0", "--seed=7",
+		"--level_distribution=info=80,warn=15,error=5"}
+	cmd, err := ts.ExecuteCmd(args)
+	ts.Require().NoError(err)
+	levels := outputLevels(ts, cmd.RawStdOut)
+	counts := map[string]int{}
+	for _, l := range levels {
+		counts[l]++
+	}
+	ts.InDelta(800, counts["INFO"], 50)
+	ts.InDelta(150, counts["WARN"], 40)
+	ts.InDelta(50, counts["ERROR"], 25)
+	ts.Len(counts, 3)
+
+	// The same seed picks the same levels
+	cmd, err = ts.ExecuteCmd(args)
+	ts.Require().NoError(err)
+	ts.Equal(levels, outputLevels(ts, cmd.RawStdOut))
+
+	config := `
+streams:
+  stderr:
+    level_distribution:
+      fatal: 1
+      trace: 0
+`
+	cmd, err = ts.ExecuteCmdWithConfig(config, []string{"--stdout=o", "--stderr=e", "--repeat=3", "--repeat_interval=0"})
+	ts.Require().NoError(err)
+	ts.Equal([]string{"INFO", "INFO", "INFO"}, outputLevels(ts, cmd.RawStdOut))
+	ts.Equal([]string{"FATAL", "FATAL", "FATAL"}, outputLevels(ts, cmd.RawStdErr))
+
+	_, err = ts.ExecuteCmd([]string{"--stdout=o", "--level_distribution=info:80"})
+	ts.ErrorAs(err, new(*paramSetValidationError))
+}
+
+func (ts *ExecTestSuite) TestLevelNames() {
+	for name, l := range map[string]slog.Level{"trace": levelTrace, "DEBUG": slog.LevelDebug, "Fatal": levelFatal, "warn-1": slog.LevelWarn - 1} {
+		parsed, err := parseLevel(name)
+		ts.NoError(err)
+		ts.Equal(l, parsed)
+	}
+	ts.Equal("TRACE", levelName(levelTrace))
+	ts.Equal("INFO", levelName(slog.LevelInfo))
+	ts.Equal(2, gelfLevel(levelFatal))
+	ts.Equal(1, otelSeverity(levelTrace))
+	ts.Equal(21, otelSeverity(levelFatal))
+}
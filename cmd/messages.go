@@ -0,0 +1,190 @@
+/*
+Copyright © 2023 Ben Orgil
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+/*
+A pool of messages a stream picks from for every line instead of writing the
+same text over and over. The picked message is interpolated like --stdout
+text would be:
+
+	et --stdout_messages='user __I__ logged in' --stdout_messages='cache miss' \
+		--stderr_messages_file=errors.txt --message_selection=random --repeat=100
+
+or in the config file, where weights can be given for message_selection=weighted:
+
+	message_selection: weighted
+	streams:
+	  stdout:
+	    messages:
+	      - text: "GET /api/v1/users 200"
+	        weight: 80
+	      - text: "GET /api/v1/users 500"
+	        weight: 5
+	      - "cache miss for user __I__"
+
+A stream's own messages start it without --stdout/--stderr being set. The
+global --messages replace the text of every stream that was started. With
+message_selection=weighted, messages from the flags and the file can start
+with a weight, ie '80:GET /api/v1/users 200'. Messages without one weigh 1.
+*/
+type message struct {
+	text   string
+	weight float64
+}
+
+// Whether the messages of a stream, or the global ones for "", are set
+func messagesSet(v *viper.Viper, stream string) bool {
+	if stream == "" {
+		return v.IsSet("messages") || v.IsSet("messages_file")
+	}
+	return v.IsSet(streamSettingKey(stream, "messages")) || v.IsSet(streamSettingKey(stream, "messages_file"))
+}
+
+// Read the messages of the flags or config file and then the messages file.
+// The key func decides which viper key each setting is read from (see streamKey())
+func getMessages(v *viper.Viper, key func(string) string) ([]message, error) {
+	weighted := v.GetString(key("message_selection")) == string(messageSelectionEnumWeighted)
+
+	var messages []message
+	add := func(text string) error {
+		m := message{text: text, weight: 1}
+		if weighted {
+			if w, t, ok := strings.Cut(text, ":"); ok {
+				if f, err := strconv.ParseFloat(w, 64); err == nil {
+					m = message{text: t, weight: f}
+				}
+			}
+		}
+		if m.weight < 0 {
+			return fmt.Errorf("%v: weight of '%v' can't be negative", key("messages"), m.text)
+		}
+		messages = append(messages, m)
+		return nil
+	}
+
+	switch val := v.Get(key("messages")).(type) {
+	case []string:
+		for _, text := range val {
+			if err := add(text); err != nil {
+				return nil, err
+			}
+		}
+	case []any:
+		for _, d := range val {
+			// A map with the text and weight of a message
+			if fields, ok := d.(map[string]any); ok {
+				text, ok := fields["text"].(string)
+				if !ok {
+					return nil, fmt.Errorf("%v: message '%v' needs a 'text'", key("messages"), d)
+				}
+				m := message{text: text, weight: 1}
+				if w, ok := fields["weight"]; ok {
+					f, err := strconv.ParseFloat(fmt.Sprint(w), 64)
+					if err != nil || f < 0 {
+						return nil, fmt.Errorf("%v: weight '%v' of '%v' must be a positive number", key("messages"), w, text)
+					}
+					m.weight = f
+				}
+				messages = append(messages, m)
+				continue
+			}
+			if err := add(fmt.Sprint(d)); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// One message per line, blank lines are skipped
+	if file := v.GetString(key("messages_file")); file != "" {
+		b, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %v", key("messages_file"), err.Error())
+		}
+		for _, line := range strings.Split(string(b), "\n") {
+			if line = strings.TrimRight(line, "\r"); line == "" {
+				continue
+			}
+			if err := add(line); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return messages, nil
+}
+
+// Picks the text of each line of a stream
+type messagePicker struct {
+	// Written when the stream has no messages
+	text      string
+	messages  []message
+	selection messageSelectionEnum
+	next      int
+	total     float64
+	rand      *rand.Rand
+}
+
+func newMessagePicker(args viperArgs, stream string, text string) *messagePicker {
+	p := &messagePicker{
+		text:      text,
+		messages:  args.messages,
+		selection: messageSelectionEnum(args.messageSelection),
+		// Its own generator so picking messages doesn't change the other random picks
+		rand: newStreamRand(args.seed, stream+"_message"),
+	}
+	for _, m := range p.messages {
+		p.total += m.weight
+	}
+	return p
+}
+
+func (p *messagePicker) pick() string {
+	if len(p.messages) == 0 {
+		return p.text
+	}
+
+	switch p.selection {
+	case messageSelectionEnumRandom:
+		return p.messages[p.rand.Intn(len(p.messages))].text
+	case messageSelectionEnumWeighted:
+		if p.total <= 0 {
+			break
+		}
+		r := p.rand.Float64() * p.total
+		for _, m := range p.messages {
+			if r < m.weight {
+				return m.text
+			}
+			r -= m.weight
+		}
+		return p.messages[len(p.messages)-1].text
+	}
+
+	m := p.messages[p.next%len(p.messages)]
+	p.next++
+	return m.text
+}
@@ -0,0 +1,107 @@
+/*
+Copyright © 2023 Ben Orgil
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func (ts *ExecTestSuite) TestMessagesRoundRobin() {
+	cmd, err := ts.ExecuteCmd([]string{"--stdout_messages=a __I__", "--stdout_messages=b __I__", "--stdout_messages=c __I__",
+		"--repeat=5", "--repeat_interval=0", "--interpolate_val=0", "--output_format=raw"})
+	ts.Require().NoError(err)
+	ts.Equal("a 0\nb 1\nc 2\na 3\nb 4\n", cmd.RawStdOut)
+	ts.Empty(cmd.RawStdErr)
+
+	// The global messages replace the text of the streams that were set
+	cmd, err = ts.ExecuteCmd([]string{"--stdout=o", "--stderr=e", "--messages=x", "--messages=y",
+		"--stderr_messages=z", "--repeat=2", "--repeat_interval=0", "--output_format=raw"})
+	ts.Require().NoError(err)
+	ts.Equal("x\ny\n", cmd.RawStdOut)
+	ts.Equal("z\nz\n", cmd.RawStdErr)
+}
+
+func (ts *ExecTestSuite) TestMessagesRandom() {
+	args := []string{"--stdout=o", "--messages=a", "--messages=b", "--messages=c", "--repeat=300", "--repeat_interval=0",
+		"--message_selection=random", "--output_format=raw", "--seed=4"}
+	cmd, err := ts.ExecuteCmd(args)
+	ts.Require().NoError(err)
+	counts := map[string]int{}
+	for _, l := range strings.Split(strings.TrimSuffix(cmd.RawStdOut, "\n"), "\n") {
+		counts[l]++
+	}
+	ts.Len(counts, 3)
+	for _, m := range []string{"a", "b", "c"} {
+		ts.InDelta(100, counts[m], 30)
+	}
+
+	// The same seed picks the same messages
+	again, err := ts.ExecuteCmd(args)
+	ts.Require().NoError(err)
+	ts.Equal(cmd.RawStdOut, again.RawStdOut)
+}
+
+func (ts *ExecTestSuite) TestMessagesWeighted() {
+	file := filepath.Join(ts.T().TempDir(), "messages.txt")
+	ts.Require().NoError(os.WriteFile(file, []byte("90:common\n\n10:rare\r\n0:never\n"), 0644))
+	cmd, err := ts.ExecuteCmd([]string{"--stderr_messages_file=" + file, "--message_selection=weighted",
+		"--repeat=500", "--repeat_interval=0", "--output_format=raw", "--seed=2"})
+	ts.Require().NoError(err)
+	counts := map[string]int{}
+	for _, l := range strings.Split(strings.TrimSuffix(cmd.RawStdErr, "\n"), "\n") {
+		counts[l]++
+	}
+	ts.InDelta(450, counts["common"], 30)
+	ts.InDelta(50, counts["rare"], 30)
+	ts.Len(counts, 2)
+
+	config := `
+message_selection: weighted
+streams:
+  stdout:
+    messages:
+      - text: "heavy __I__"
+        weight: 1
+      - text: "light"
+        weight: 0
+      - "plain"
+`
+	cmd, err = ts.ExecuteCmdWithConfig(config, []string{"--repeat=50", "--repeat_interval=0", "--output_format=raw", "--interpolator=string", "--interpolate_val=x"})
+	ts.Require().NoError(err)
+	ts.NotContains(cmd.RawStdOut, "light")
+	ts.Contains(cmd.RawStdOut, "heavy x\n")
+	ts.Contains(cmd.RawStdOut, "plain\n")
+}
+
+func (ts *ExecTestSuite) TestMessagesValidation() {
+	for _, args := range [][]string{
+		{"--messages=a"},
+		{"--stdout_messages_file=/does/not/exist"},
+	} {
+		_, err := ts.ExecuteCmd(args)
+		ts.ErrorAs(err, new(*paramSetValidationError), args)
+	}
+
+	_, err := ts.ExecuteCmd([]string{"--stdout_messages=a", "--message_selection=shuffle"})
+	ts.Error(err)
+	_, err = ts.ExecuteCmdWithConfig("message_selection: shuffle", []string{"--stdout_messages=a"})
+	ts.ErrorAs(err, new(*paramSetValidationError))
+	_, err = ts.ExecuteCmdWithConfig("messages: [{weight: 2}]", []string{"--stdout=o"})
+	ts.ErrorAs(err, new(*paramSetValidationError))
+}
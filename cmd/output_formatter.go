@@ -18,13 +18,15 @@ package cmd
 
 import (
 	"bytes"
-	"fmt"
+	"context"
 	"io"
 	"log/slog"
 	"os"
-	"regexp"
+	"strconv"
+	"strings"
 
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
 
 	"github.com/benorgil/exectester/configs"
 )
@@ -55,40 +57,115 @@ type OutputFormatter struct {
 	CobraLoggerStderr *slog.Logger
 	// The structure of output fields
 	LogSchema *configs.OutputFormat
+
+	// output_format=raw skips the loggers and writes the text as is
+	Raw bool
+	// Written after each line in raw mode
+	LineTerminator string
+	// Decode escape sequences like '\t' and '\x1b' in the text before it's written
+	DecodeEscapes bool
+	// Writes the lines in parts for the partial write settings. Nil writes them in one go
+	Partial *partialWriter
 }
 
-// By default slog prints literal '\n' new line chars
-// Using this function to swallow log lines and manually print with Println()
-func humanReadableReplaceAttr() func(groups []string, a slog.Attr) slog.Attr {
-	return func(groups []string, a slog.Attr) slog.Attr {
-		if a.Key == "msg" {
-			re := regexp.MustCompile("^msg=")
-			s := re.ReplaceAllString(a.String(), "")
-			fmt.Println(s)
+// Turn escape sequences typed on the command line into the characters they
+// stand for. Supports everything Go string literals do ('\t', '\x1b',
+// '\u00e9', ...) plus '\e' for ESC. Anything else is left as typed
+func decodeEscapeSequences(s string) string {
+	var b strings.Builder
+	for len(s) > 0 {
+		if s[0] != '\\' {
+			b.WriteByte(s[0])
+			s = s[1:]
+			continue
+		}
+		if strings.HasPrefix(s, `\e`) {
+			b.WriteByte(0x1b)
+			s = s[2:]
+			continue
+		}
+		v, multibyte, tail, err := strconv.UnquoteChar(s, 0)
+		if err != nil {
+			b.WriteByte(s[0])
+			s = s[1:]
+			continue
+		}
+		// '\xff' is a single byte, not the rune U+00FF
+		if multibyte {
+			b.WriteRune(v)
+		} else {
+			b.WriteByte(byte(v))
 		}
-		a = slog.Attr{}
-		return a
+		s = tail
 	}
+	return b.String()
 }
 
-// Write to stdout via cobra method
-func (a *OutputFormatter) cobraStdout(cmd *cobra.Command, output string) {
-	a.CobraLoggerStdout.Info(output)
+// Write a line as is, for output_format=raw
+func (a *OutputFormatter) rawWrite(ctx context.Context, w io.Writer, output string) int {
+	n, _ := a.Partial.write(ctx, w, output+a.LineTerminator, a.LineTerminator)
+	return n
+}
+
+// Write to stdout via cobra method at the given level. Fields are slog
+// key/value args added to the line, raw output has nowhere to put them. ctx
+// stops a partial write (see partialWriter). Returns the number of bytes written
+func (a *OutputFormatter) cobraStdout(ctx context.Context, cmd *cobra.Command, output string, level slog.Level, fields ...any) int {
+	if a.DecodeEscapes {
+		output = decodeEscapeSequences(output)
+	}
+	if a.Raw {
+		return a.rawWrite(ctx, cmd.OutOrStdout(), output)
+	}
+	a.CobraLoggerStdout.Log(context.Background(), level, output, fields...)
 	out, _ := io.ReadAll(a.BuffOut)
-	fmt.Fprint(cmd.OutOrStdout(), string(out))
+	n, _ := a.Partial.write(ctx, cmd.OutOrStdout(), string(out), "\n")
+	return n
 }
 
-// Write to stderr via cobra method
-func (a *OutputFormatter) cobraStderr(cmd *cobra.Command, output string) {
-	a.CobraLoggerStderr.Info(output)
+// Write to stderr via cobra method. Same as cobraStdout()
+func (a *OutputFormatter) cobraStderr(ctx context.Context, cmd *cobra.Command, output string, level slog.Level, fields ...any) int {
+	if a.DecodeEscapes {
+		output = decodeEscapeSequences(output)
+	}
+	if a.Raw {
+		return a.rawWrite(ctx, cmd.ErrOrStderr(), output)
+	}
+	a.CobraLoggerStderr.Log(context.Background(), level, output, fields...)
 	out, _ := io.ReadAll(a.BuffErr)
-	fmt.Fprint(cmd.ErrOrStderr(), string(out))
+	n, _ := a.Partial.write(ctx, cmd.ErrOrStderr(), string(out), "\n")
+	return n
+}
+
+// Handler options naming the custom levels, renaming the built-in keys and
+// formatting the time. The second set is for the output lines which are
+// never filtered by level. Broken renames are caught by validateParamSets()
+// so they're ignored here
+func (a *OutputFormatter) builtinHandlerOptions(v *viper.Viper) (*slog.HandlerOptions, *slog.HandlerOptions) {
+	renames, _ := getFieldRenames(v)
+	replaceAttr := builtinReplaceAttr(renames, v.GetString("time_format"))
+	return &slog.HandlerOptions{ReplaceAttr: replaceAttr},
+		&slog.HandlerOptions{Level: levelAll, ReplaceAttr: replaceAttr}
+}
+
+// The format func for the formats using recordHandler. A broken
+// output_template is caught by validateParamSets() so it's not reported here
+func (a *OutputFormatter) recordFormat(v *viper.Viper, loggerType string) (recordFormat, bool) {
+	if loggerType == string(outputFormatterEnumTemplate) {
+		t, err := parseRecordTemplate(v.GetString("output_template"))
+		if err != nil {
+			return nil, false
+		}
+		return templateRecordFormat(t), true
+	}
+	format, ok := recordFormats[loggerType]
+	return format, ok
 }
 
 // This should be called after the config is loaded to get the right logger
 // If loggerType is "" a default is set and the logger config field is checked
 // from env var
-func (a *OutputFormatter) getLogger(loggerType string) OutputFormatter {
+func (a *OutputFormatter) getLogger(v *viper.Viper, loggerType string) OutputFormatter {
 	logger := OutputFormatter{
 		BuffOut:   bytes.NewBufferString(""),
 		BuffErr:   bytes.NewBufferString(""),
@@ -101,19 +178,26 @@ func (a *OutputFormatter) getLogger(loggerType string) OutputFormatter {
 	}
 
 	if loggerType == "human_readable" {
-		logger.Logger = slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
-			ReplaceAttr: humanReadableReplaceAttr(),
-		}))
-		logger.CobraLoggerStdout = slog.New(slog.NewTextHandler(logger.BuffOut, &slog.HandlerOptions{
-			ReplaceAttr: humanReadableReplaceAttr(),
-		}))
-		logger.CobraLoggerStderr = slog.New(slog.NewTextHandler(logger.BuffErr, &slog.HandlerOptions{
-			ReplaceAttr: humanReadableReplaceAttr(),
-		}))
+		cobraOpts := &slog.HandlerOptions{Level: levelAll}
+		logger.Logger = slog.New(newRecordHandler(os.Stdout, nil, humanReadableRecordFormat))
+		logger.CobraLoggerStdout = slog.New(newRecordHandler(logger.BuffOut, cobraOpts, humanReadableRecordFormat))
+		logger.CobraLoggerStderr = slog.New(newRecordHandler(logger.BuffErr, cobraOpts, humanReadableRecordFormat))
 	} else if loggerType == "structured" {
-		logger.Logger = slog.New(slog.NewJSONHandler(os.Stdout, nil))
-		logger.CobraLoggerStdout = slog.New(slog.NewJSONHandler(logger.BuffOut, nil))
-		logger.CobraLoggerStderr = slog.New(slog.NewJSONHandler(logger.BuffErr, nil))
+		opts, cobraOpts := a.builtinHandlerOptions(v)
+		logger.Logger = slog.New(slog.NewJSONHandler(os.Stdout, opts))
+		logger.CobraLoggerStdout = slog.New(slog.NewJSONHandler(logger.BuffOut, cobraOpts))
+		logger.CobraLoggerStderr = slog.New(slog.NewJSONHandler(logger.BuffErr, cobraOpts))
+	} else if loggerType == "raw" {
+		// Nothing to format. Only warnings and errors are logged, and to
+		// stderr, so stdout stays exactly what was asked for
+		logger.Raw = true
+		logger.LineTerminator = "\n"
+		logger.Logger = slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelWarn}))
+	} else if format, ok := a.recordFormat(v, loggerType); ok {
+		opts, cobraOpts := a.builtinHandlerOptions(v)
+		logger.Logger = slog.New(newRecordHandler(os.Stdout, opts, format))
+		logger.CobraLoggerStdout = slog.New(newRecordHandler(logger.BuffOut, cobraOpts, format))
+		logger.CobraLoggerStderr = slog.New(newRecordHandler(logger.BuffErr, cobraOpts, format))
 	}
 
 	return logger
@@ -0,0 +1,342 @@
+/*
+Copyright © 2023 Ben Orgil
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+/*
+Slog only comes with a text and a JSON handler. The other output formats
+(human_readable, logfmt, ecs, gelf, otel and template) share this handler, which does the
+slog plumbing and hands each record to a format func that writes one line.
+
+Attributes are flattened before they reach the format func. Groups become
+dotted keys, ie slog.Group("http", "status", 200) is "http.status".
+
+The time, level and msg of the record are also passed as attributes after
+going through the ReplaceAttr of the handler options. Formats with a free
+schema (logfmt) write those so the keys can be renamed, the others have a
+fixed schema and read them from the record.
+*/
+type recordHandler struct {
+	w      io.Writer
+	mu     *sync.Mutex
+	opts   slog.HandlerOptions
+	attrs  []slog.Attr
+	prefix string
+	format recordFormat
+}
+
+// Writes a single record to buf, without the trailing new line
+type recordFormat func(buf *bytes.Buffer, r slog.Record, builtin []slog.Attr, attrs []slog.Attr) error
+
+func newRecordHandler(w io.Writer, opts *slog.HandlerOptions, format recordFormat) *recordHandler {
+	h := &recordHandler{w: w, mu: &sync.Mutex{}, format: format}
+	if opts != nil {
+		h.opts = *opts
+	}
+	if h.opts.Level == nil {
+		h.opts.Level = slog.LevelInfo
+	}
+	return h
+}
+
+func (h *recordHandler) Enabled(_ context.Context, l slog.Level) bool {
+	return l >= h.opts.Level.Level()
+}
+
+func (h *recordHandler) Handle(_ context.Context, r slog.Record) error {
+	var builtin []slog.Attr
+	for _, a := range []slog.Attr{
+		slog.Time(slog.TimeKey, r.Time),
+		slog.String(slog.LevelKey, levelName(r.Level)),
+		slog.String(slog.MessageKey, r.Message),
+	} {
+		if h.opts.ReplaceAttr != nil {
+			a = h.opts.ReplaceAttr(nil, a)
+		}
+		if !a.Equal(slog.Attr{}) {
+			builtin = append(builtin, a)
+		}
+	}
+
+	attrs := slices.Clone(h.attrs)
+	r.Attrs(func(a slog.Attr) bool {
+		attrs = flattenAttr(attrs, h.prefix, a)
+		return true
+	})
+
+	buf := &bytes.Buffer{}
+	if err := h.format(buf, r, builtin, attrs); err != nil {
+		return err
+	}
+	buf.WriteByte('\n')
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := h.w.Write(buf.Bytes())
+	return err
+}
+
+func (h *recordHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	h2 := *h
+	h2.attrs = slices.Clone(h.attrs)
+	for _, a := range attrs {
+		h2.attrs = flattenAttr(h2.attrs, h.prefix, a)
+	}
+	return &h2
+}
+
+func (h *recordHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	h2 := *h
+	h2.prefix += name + "."
+	return &h2
+}
+
+// Append an attribute, expanding groups into dotted keys
+func flattenAttr(attrs []slog.Attr, prefix string, a slog.Attr) []slog.Attr {
+	a.Value = a.Value.Resolve()
+	if a.Equal(slog.Attr{}) {
+		return attrs
+	}
+	if a.Value.Kind() == slog.KindGroup {
+		if a.Key != "" {
+			prefix += a.Key + "."
+		}
+		for _, g := range a.Value.Group() {
+			attrs = flattenAttr(attrs, prefix, g)
+		}
+		return attrs
+	}
+	a.Key = prefix + a.Key
+	if v, ok := a.Value.Any().(fieldValue); ok {
+		a.Value = slog.StringValue(string(v))
+	}
+	return append(attrs, a)
+}
+
+// Writes a JSON object field by field so the fields keep their order
+type jsonObject struct {
+	buf    *bytes.Buffer
+	fields int
+}
+
+func newJsonObject(buf *bytes.Buffer) *jsonObject {
+	buf.WriteByte('{')
+	return &jsonObject{buf: buf}
+}
+
+func (o *jsonObject) field(key string, v any) error {
+	if o.fields > 0 {
+		o.buf.WriteByte(',')
+	}
+	o.fields++
+	k, _ := json.Marshal(key)
+	o.buf.Write(k)
+	o.buf.WriteByte(':')
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	o.buf.Write(b)
+	return nil
+}
+
+func (o *jsonObject) close() {
+	o.buf.WriteByte('}')
+}
+
+// Map of the flattened attributes
+func attrsMap(attrs []slog.Attr) map[string]any {
+	m := map[string]any{}
+	for _, a := range attrs {
+		m[a.Key] = a.Value.Any()
+	}
+	return m
+}
+
+// Formats are picked by their output_format value. template isn't here
+// since it needs the user's template, see templateRecordFormat()
+var recordFormats = map[string]recordFormat{
+	"logfmt": logfmtRecordFormat,
+	"ecs":    ecsRecordFormat,
+	"gelf":   gelfRecordFormat,
+	"otel":   otelRecordFormat,
+}
+
+// human_readable is just the message. Slog's TextHandler would quote it and
+// escape its new lines, which breaks multi-line messages like stack traces
+func humanReadableRecordFormat(buf *bytes.Buffer, r slog.Record, _ []slog.Attr, _ []slog.Attr) error {
+	buf.WriteString(r.Message)
+	return nil
+}
+
+// logfmt: time=2023-11-14T22:13:20.5Z level=INFO msg="hello world" key=value
+func logfmtRecordFormat(buf *bytes.Buffer, r slog.Record, builtin []slog.Attr, attrs []slog.Attr) error {
+	for i, a := range append(builtin, attrs...) {
+		if i > 0 {
+			buf.WriteByte(' ')
+		}
+		v := a.Value.String()
+		if a.Value.Kind() == slog.KindTime {
+			v = a.Value.Time().Format(time.RFC3339Nano)
+		}
+		buf.WriteString(a.Key + "=" + logfmtValue(v))
+	}
+	return nil
+}
+
+// Values with spaces, quotes, '=' or control characters have to be quoted
+func logfmtValue(v string) string {
+	if v == "" || strings.ContainsAny(v, " =\"\\") || strings.ContainsFunc(v, func(r rune) bool { return r < ' ' }) {
+		return strconv.Quote(v)
+	}
+	return v
+}
+
+// Elastic Common Schema. Attributes are added as they are, dotted keys are
+// how ECS nests fields anyway
+const ecsVersion = "8.11.0"
+
+func ecsRecordFormat(buf *bytes.Buffer, r slog.Record, _ []slog.Attr, attrs []slog.Attr) error {
+	o := newJsonObject(buf)
+	o.field("@timestamp", r.Time.UTC().Format(time.RFC3339Nano))
+	o.field("log.level", strings.ToLower(levelName(r.Level)))
+	o.field("message", r.Message)
+	o.field("ecs.version", ecsVersion)
+	for _, a := range attrs {
+		if err := o.field(a.Key, a.Value.Any()); err != nil {
+			return err
+		}
+	}
+	o.close()
+	return nil
+}
+
+// GELF 1.1 (Graylog). Additional fields have to be prefixed with '_' and can
+// only use word characters, '.' and '-'
+var (
+	gelfHost          = sync.OnceValue(func() string { h, _ := os.Hostname(); return h })
+	gelfFieldRegexp   = regexp.MustCompile(`[^\w\.\-]`)
+	gelfReservedField = "_id"
+)
+
+func gelfRecordFormat(buf *bytes.Buffer, r slog.Record, _ []slog.Attr, attrs []slog.Attr) error {
+	o := newJsonObject(buf)
+	o.field("version", "1.1")
+	o.field("host", gelfHost())
+	o.field("short_message", r.Message)
+	o.field("timestamp", float64(r.Time.UnixMicro())/1e6)
+	o.field("level", gelfLevel(r.Level))
+	for _, a := range attrs {
+		k := "_" + gelfFieldRegexp.ReplaceAllString(a.Key, "_")
+		if k == gelfReservedField {
+			k += "_"
+		}
+		if err := o.field(k, a.Value.Any()); err != nil {
+			return err
+		}
+	}
+	o.close()
+	return nil
+}
+
+// GELF uses syslog severities
+func gelfLevel(l slog.Level) int {
+	switch {
+	case l >= levelFatal:
+		return 2
+	case l >= slog.LevelError:
+		return 3
+	case l >= slog.LevelWarn:
+		return 4
+	case l >= slog.LevelInfo:
+		return 6
+	default:
+		return 7
+	}
+}
+
+// OpenTelemetry log data model. Timestamps are nanoseconds as strings like
+// OTLP JSON does since they don't fit in a float64
+func otelRecordFormat(buf *bytes.Buffer, r slog.Record, _ []slog.Attr, attrs []slog.Attr) error {
+	ts := strconv.FormatInt(r.Time.UnixNano(), 10)
+	o := newJsonObject(buf)
+	o.field("Timestamp", ts)
+	o.field("ObservedTimestamp", ts)
+	o.field("SeverityText", levelName(r.Level))
+	o.field("SeverityNumber", otelSeverity(r.Level))
+	o.field("Body", r.Message)
+	o.field("Resource", map[string]any{"service.name": "et", "process.pid": os.Getpid()})
+	if err := o.field("Attributes", attrsMap(attrs)); err != nil {
+		return err
+	}
+	o.close()
+	return nil
+}
+
+// Slog's levels are 4 apart like OTel's severity ranges, with INFO at 0
+// instead of 9. See https://opentelemetry.io/docs/specs/otel/logs/data-model/#field-severitynumber
+func otelSeverity(l slog.Level) int {
+	return min(max(int(l)+9, 1), 24)
+}
+
+// The data available to --output_template
+type templateRecord struct {
+	Time  time.Time
+	Level string
+	Msg   string
+	// The flattened attributes
+	Attrs map[string]any
+}
+
+func parseRecordTemplate(t string) (*template.Template, error) {
+	return template.New("output_template").Funcs(templateFuncs).Option("missingkey=zero").Parse(t)
+}
+
+// A format rendering each record with the user's template
+func templateRecordFormat(t *template.Template) recordFormat {
+	return func(buf *bytes.Buffer, r slog.Record, _ []slog.Attr, attrs []slog.Attr) error {
+		err := t.Execute(buf, templateRecord{
+			Time:  r.Time,
+			Level: levelName(r.Level),
+			Msg:   r.Message,
+			Attrs: attrsMap(attrs),
+		})
+		if err != nil {
+			return fmt.Errorf("output_template: %v", err.Error())
+		}
+		return nil
+	}
+}
@@ -0,0 +1,146 @@
+/*
+Copyright © 2023 Ben Orgil
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Parse a logfmt line into its keys and values
+func parseLogfmt(line string) (map[string]string, error) {
+	m := map[string]string{}
+	for line != "" {
+		k, rest, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("no '=' in '%v'", line)
+		}
+		var v string
+		if strings.HasPrefix(rest, `"`) {
+			q, err := strconv.QuotedPrefix(rest)
+			if err != nil {
+				return nil, err
+			}
+			v, _ = strconv.Unquote(q)
+			rest = rest[len(q):]
+		} else {
+			v, rest, _ = strings.Cut(rest, " ")
+			rest = " " + rest
+		}
+		m[k] = v
+		line = strings.TrimPrefix(rest, " ")
+	}
+	return m, nil
+}
+
+// Parse a line of one of the recordHandler formats back into its time,
+// level and message, like readB() does for structured output
+func parseRecordLine(format string, line string) (time.Time, string, string, error) {
+	if format == "logfmt" {
+		m, err := parseLogfmt(line)
+		if err != nil {
+			return time.Time{}, "", "", err
+		}
+		t, err := time.Parse(time.RFC3339Nano, m["time"])
+		return t, m["level"], m["msg"], err
+	}
+
+	m := map[string]any{}
+	if err := json.Unmarshal([]byte(line), &m); err != nil {
+		return time.Time{}, "", "", err
+	}
+	switch format {
+	case "ecs":
+		t, err := time.Parse(time.RFC3339Nano, m["@timestamp"].(string))
+		return t, strings.ToUpper(m["log.level"].(string)), m["message"].(string), err
+	case "gelf":
+		ts := m["timestamp"].(float64)
+		level := map[float64]string{3: "ERROR", 4: "WARN", 6: "INFO", 7: "DEBUG"}[m["level"].(float64)]
+		return time.UnixMicro(int64(ts * 1e6)), level, m["short_message"].(string), nil
+	case "otel":
+		ns, err := strconv.ParseInt(m["Timestamp"].(string), 10, 64)
+		return time.Unix(0, ns), m["SeverityText"].(string), m["Body"].(string), err
+	}
+	return time.Time{}, "", "", fmt.Errorf("unknown format '%v'", format)
+}
+
+func (ts *ExecTestSuite) TestOutputFormats() {
+	msg := `hello "world" a=b`
+	for _, format := range []string{"logfmt", "ecs", "gelf", "otel"} {
+		now := time.Now()
+		cmd, err := ts.ExecuteCmd([]string{"--stdout=" + msg, "--stderr=e__I__", "--repeat=2", "--repeat_interval=0",
+			"--output_format=" + format})
+		ts.Require().NoError(err, format)
+
+		lines := strings.Split(strings.TrimSuffix(cmd.RawStdOut, "\n"), "\n")
+		ts.Require().Len(lines, 2, format)
+		t, level, m, err := parseRecordLine(format, lines[0])
+		ts.NoError(err, format)
+		ts.Equal(msg, m, format)
+		ts.Equal("INFO", level, format)
+		ts.WithinDuration(now, t, time.Second, format)
+
+		_, _, m, err = parseRecordLine(format, strings.Split(cmd.RawStdErr, "\n")[1])
+		ts.NoError(err, format)
+		ts.Equal("e1", m, format)
+	}
+}
+
+func (ts *ExecTestSuite) TestOutputTemplate() {
+	cmd, err := ts.ExecuteCmd([]string{"--stdout=o__I__", "--repeat=2", "--repeat_interval=0",
+		"--output_format=template", "--output_template=[{{.Level}}] {{.Msg}} {{upper .Msg}}"})
+	ts.NoError(err)
+	ts.Equal("[INFO] o0 O0\n[INFO] o1 O1\n", cmd.RawStdOut)
+
+	_, err = ts.ExecuteCmd([]string{"--stdout=o", "--output_format=template", "--output_template={{.Msg"})
+	ts.ErrorAs(err, new(*paramSetValidationError))
+}
+
+func (ts *ExecTestSuite) TestRecordHandlerAttrs() {
+	buf := &bytes.Buffer{}
+	logger := slog.New(newRecordHandler(buf, nil, ecsRecordFormat)).With("a", 1).WithGroup("g")
+	logger.Debug("not logged")
+	logger.Info("m", "b", "x y", slog.Group("h", "c", 3))
+	ts.Equal(`{"@timestamp":"`, buf.String()[:15])
+	m := map[string]any{}
+	ts.Require().NoError(json.Unmarshal(buf.Bytes(), &m))
+	ts.Equal(float64(1), m["a"])
+	ts.Equal("x y", m["g.b"])
+	ts.Equal(float64(3), m["g.h.c"])
+
+	buf.Reset()
+	slog.New(newRecordHandler(buf, nil, logfmtRecordFormat)).Warn("m", "b", "x y", "c", "")
+	kv, err := parseLogfmt(strings.TrimSuffix(buf.String(), "\n"))
+	ts.NoError(err)
+	ts.Equal(map[string]string{"time": kv["time"], "level": "WARN", "msg": "m", "b": "x y", "c": ""}, kv)
+
+	buf.Reset()
+	slog.New(newRecordHandler(buf, nil, gelfRecordFormat)).Error("m", "id", 1, "a b", 2)
+	m = map[string]any{}
+	ts.Require().NoError(json.Unmarshal(buf.Bytes(), &m))
+	ts.Equal(float64(3), m["level"])
+	ts.Equal(float64(1), m["_id_"])
+	ts.Equal(float64(2), m["_a_b"])
+
+	ts.Equal(5, otelSeverity(slog.LevelDebug))
+	ts.Equal(17, otelSeverity(slog.LevelError))
+}
@@ -0,0 +1,116 @@
+/*
+Copyright © 2023 Ben Orgil
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"context"
+	"io"
+	"strings"
+	"time"
+)
+
+/*
+Consumers that read line by line often assume every read ends on a line
+break. These settings break that assumption:
+
+  - write_chunks: split every line into this many writes, write_delay apart.
+    '2' writes half a line, waits, then writes the rest
+  - trickle: write one byte at a time, write_delay apart
+  - no_final_newline: leave the line terminator off the last line, as a
+    process killed mid line would. The last line is only known when a
+    stream ends by its repeat count or at the end of its file, not by a
+    timeout or signal
+
+They apply to stdout, stderr and the socket and can be set per stream like
+the other stream settings. Lines are split on bytes so a multibyte
+character can be split too. A line being written when the output stops, by
+a timeout, a signal action or a shutdown, is left unfinished.
+
+	et --stdout='a slow line' --write_chunks=2 --write_delay=2s
+*/
+type partialWriter struct {
+	chunks         int
+	trickle        bool
+	delay          time.Duration
+	noFinalNewline bool
+	// Whether the next line is the stream's last
+	final bool
+}
+
+// Returns nil if lines of the stream are written in one go
+func newPartialWriter(args viperArgs) *partialWriter {
+	if args.writeChunks <= 1 && !args.trickle && !args.noFinalNewline {
+		return nil
+	}
+	return &partialWriter{
+		chunks:         max(args.writeChunks, 1),
+		trickle:        args.trickle,
+		delay:          args.writeDelay,
+		noFinalNewline: args.noFinalNewline,
+	}
+}
+
+// Split a line into the parts it's written in
+func (p *partialWriter) split(line string) []string {
+	size := len(line)
+	if p.trickle {
+		size = 1
+	} else if p.chunks > 1 {
+		size = (len(line) + p.chunks - 1) / p.chunks
+	}
+	if size < 1 {
+		return []string{line}
+	}
+
+	var parts []string
+	for len(line) > size {
+		parts = append(parts, line[:size])
+		line = line[size:]
+	}
+	return append(parts, line)
+}
+
+// Write a line ending in terminator. A nil partialWriter writes it in one go.
+// The line is left unfinished if ctx is done while pausing between two parts.
+// Returns the number of bytes written
+func (p *partialWriter) write(ctx context.Context, w io.Writer, line string, terminator string) (int, error) {
+	if p == nil {
+		return io.WriteString(w, line)
+	}
+	if p.final && p.noFinalNewline {
+		line = strings.TrimSuffix(line, terminator)
+	}
+
+	written := 0
+	for i, part := range p.split(line) {
+		if i > 0 && p.delay > 0 {
+			timer := time.NewTimer(p.delay)
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				return written, ctx.Err()
+			}
+		}
+		n, err := io.WriteString(w, part)
+		written += n
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
@@ -0,0 +1,144 @@
+/*
+Copyright © 2023 Ben Orgil
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"context"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Keeps every write separate
+type writeRecorder struct {
+	writes []string
+}
+
+func (r *writeRecorder) Write(p []byte) (int, error) {
+	r.writes = append(r.writes, string(p))
+	return len(p), nil
+}
+
+func (ts *ExecTestSuite) TestPartialWriterSplit() {
+	r := &writeRecorder{}
+	p := &partialWriter{chunks: 2}
+	n, err := p.write(context.Background(), r, "a slow line\n", "\n")
+	ts.NoError(err)
+	ts.Equal(12, n)
+	ts.Equal([]string{"a slow", " line\n"}, r.writes)
+
+	r = &writeRecorder{}
+	p = &partialWriter{chunks: 3, noFinalNewline: true, final: true}
+	p.write(context.Background(), r, "abcdefg\r\n", "\r\n")
+	ts.Equal([]string{"abc", "def", "g"}, r.writes)
+
+	r = &writeRecorder{}
+	p = &partialWriter{chunks: 1, trickle: true, delay: 10 * time.Millisecond}
+	start := time.Now()
+	p.write(context.Background(), r, "é\n", "\n")
+	ts.Equal([]string{"\xc3", "\xa9", "\n"}, r.writes)
+	ts.GreaterOrEqual(time.Since(start), 20*time.Millisecond)
+
+	// Nothing to do without any of the settings
+	ts.Nil(newPartialWriter(viperArgs{streamArgs: streamArgs{writeChunks: 1}}))
+	r = &writeRecorder{}
+	(*partialWriter)(nil).write(context.Background(), r, "line\n", "\n")
+	ts.Equal([]string{"line\n"}, r.writes)
+}
+
+func (ts *ExecTestSuite) TestPartialWrites() {
+	cmd, err := ts.ExecuteCmd([]string{"--stdout=line__I__", "--stderr=e", "--repeat=3", "--repeat_interval=0",
+		"--output_format=raw", "--stdout_write_chunks=2", "--write_delay=0", "--stdout_no_final_newline"})
+	ts.Require().NoError(err)
+	ts.Equal("line0\nline1\nline2", cmd.RawStdOut)
+	ts.Equal("e\ne\ne\n", cmd.RawStdErr)
+
+	// The formatted line loses its newline too
+	cmd, err = ts.ExecuteCmd([]string{"--stderr=e", "--trickle", "--write_delay=0", "--no_final_newline"})
+	ts.Require().NoError(err)
+	ts.Regexp(`^\{.*"msg":"e"\}$`, cmd.RawStdErr)
+
+	// So does the last line of a file
+	file := filepath.Join(ts.T().TempDir(), "lines.txt")
+	ts.Require().NoError(os.WriteFile(file, []byte("a\nb\n"), 0644))
+	cmd, err = ts.ExecuteCmd([]string{"--stdout_file=" + file, "--repeat_interval=0", "--output_format=raw", "--no_final_newline"})
+	ts.Require().NoError(err)
+	ts.Equal("a\nb", cmd.RawStdOut)
+}
+
+func (ts *ExecTestSuite) TestPartialWritesStop() {
+	// The timeout cuts the line short instead of waiting for its 9 pauses
+	start := time.Now()
+	cmd, err := ts.ExecuteCmd([]string{"--stdout=abcdefghij", "--trickle", "--write_delay=1s", "--timeout=500ms",
+		"--output_format=raw"})
+	ts.Require().NoError(err)
+	ts.Equal("a", cmd.RawStdOut)
+	ts.Less(time.Since(start), 5*time.Second)
+}
+
+func (ts *ExecTestSuite) TestPartialWritesSocket() {
+	socketFile := filepath.Join(os.TempDir(), "ExecTestSuite_TestPartialWritesSocket.sock")
+	os.Remove(socketFile)
+	l, err := net.Listen("unix", socketFile)
+	ts.Require().NoError(err)
+	defer os.Remove(socketFile)
+	defer l.Close()
+
+	reads := make(chan []string, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		var got []string
+		buf := make([]byte, 1024)
+		for {
+			n, err := conn.Read(buf)
+			if n > 0 {
+				got = append(got, string(buf[:n]))
+			}
+			if err == io.EOF {
+				break
+			}
+		}
+		reads <- got
+	}()
+
+	_, err = ts.ExecuteCmd([]string{"--socket=" + socketFile, "--socket_send=half", "--socket_write_chunks=2",
+		"--write_delay=50ms"})
+	ts.Require().NoError(err)
+	ts.Equal([]string{"hal", "f\n"}, <-reads)
+}
+
+func (ts *ExecTestSuite) TestPartialWritesValidation() {
+	for _, tc := range []struct {
+		name string
+		args []string
+	}{
+		{"zero write_chunks", []string{"--stdout=o", "--write_chunks=0"}},
+		{"negative stream write_chunks", []string{"--stdout=o", "--stderr_write_chunks=-1"}},
+		{"negative write_delay", []string{"--stdout=o", "--write_delay=-1s"}},
+	} {
+		ts.Run(tc.name, func() {
+			_, err := ts.ExecuteCmd(tc.args)
+			ts.ErrorAs(err, new(*paramSetValidationError), tc.args)
+		})
+	}
+}
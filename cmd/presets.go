@@ -0,0 +1,257 @@
+/*
+Copyright © 2023 Ben Orgil
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+/*
+The "preset" interpolator replaces the key with a whole synthetic log line
+in a well known format, with random client IPs, paths, status codes,
+latencies and user agents:
+
+	et --stdout=__I__ --interpolator=preset --interpolate_val=nginx \
+		--repeat_forever --output_format=raw --seed=42
+
+or mixed with other text and interpolations:
+
+	et --stdout='web1 __LOG__' --interpolate=__LOG__=preset:syslog5424
+
+Use --output_format=raw so the lines aren't wrapped in the JSON or text of
+the other formats. The values are picked reproducibly with --seed.
+*/
+type logPreset func(r *rand.Rand, t time.Time) string
+
+var logPresets = map[string]logPreset{
+	"nginx":      nginxPreset,
+	"apache":     apachePreset,
+	"syslog3164": syslog3164Preset,
+	"syslog5424": syslog5424Preset,
+	"klog":       klogPreset,
+	"json_app":   jsonAppPreset,
+}
+
+// Sorted names of the presets for help and error messages
+var logPresetNames = func() []string {
+	names := make([]string, 0, len(logPresets))
+	for n := range logPresets {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}()
+
+func checkPreset(name string) error {
+	if _, ok := logPresets[name]; !ok {
+		return fmt.Errorf("preset '%v' must be one of: %v", name, strings.Join(logPresetNames, ", "))
+	}
+	return nil
+}
+
+// The value the preset interpolator produces for a line
+func interpolatePreset(name string, ctx interpolateContext) (string, error) {
+	if err := checkPreset(name); err != nil {
+		return "", err
+	}
+	return logPresets[name](ctx.rand, ctx.IterationStartTime), nil
+}
+
+var (
+	presetMethods = []string{"GET", "GET", "GET", "GET", "POST", "POST", "PUT", "DELETE"}
+	presetPaths   = []string{
+		"/", "/index.html", "/api/v1/users", "/api/v1/users/1042", "/api/v1/orders",
+		"/api/v1/orders/98231/items", "/login", "/static/css/main.css", "/static/js/app.js",
+		"/healthz", "/favicon.ico", "/search?q=shoes&page=2",
+	}
+	presetAgents = []string{
+		"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36",
+		"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.1 Safari/605.1.15",
+		"Mozilla/5.0 (X11; Linux x86_64; rv:121.0) Gecko/20100101 Firefox/121.0",
+		"Mozilla/5.0 (iPhone; CPU iPhone OS 17_1 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Mobile/15E148",
+		"curl/8.4.0",
+		"kube-probe/1.28",
+		"Googlebot/2.1 (+http://www.google.com/bot.html)",
+	}
+	presetReferers = []string{"-", "-", "https://www.example.com/", "https://www.google.com/"}
+	presetUsers    = []string{"-", "-", "-", "alice", "bob"}
+	presetHosts    = []string{"web-1", "web-2", "api-7f9c", "db-0"}
+	presetApps     = []string{"sshd", "cron", "kernel", "systemd", "nginx", "app"}
+	presetMessages = []string{
+		"Accepted publickey for deploy from 10.0.4.17 port 52144 ssh2",
+		"session opened for user root by (uid=0)",
+		"Started Daily apt download activities.",
+		"connection reset by peer",
+		"cache miss, fetching from upstream",
+		"request completed",
+		"retrying request after timeout",
+		"failed to connect to database: connection refused",
+	}
+	presetKlogFiles = []string{"controller.go", "reflector.go", "leaderelection.go", "server.go", "round_trippers.go"}
+)
+
+// A random item of a pool
+func presetPick(r *rand.Rand, s []string) string {
+	return s[r.Intn(len(s))]
+}
+
+// A random public looking IPv4 address
+func presetIP(r *rand.Rand) string {
+	return fmt.Sprintf("%d.%d.%d.%d", 1+r.Intn(223), r.Intn(256), r.Intn(256), 1+r.Intn(254))
+}
+
+// Mostly 200s with some redirects, client and server errors
+func presetStatus(r *rand.Rand) int {
+	switch n := r.Intn(100); {
+	case n < 80:
+		return 200
+	case n < 85:
+		return presetPickInt(r, 201, 204)
+	case n < 90:
+		return presetPickInt(r, 301, 304)
+	case n < 97:
+		return presetPickInt(r, 404, 400)
+	default:
+		return presetPickInt(r, 500, 503)
+	}
+}
+
+// One of two values, ie two status codes of the same class
+func presetPickInt(r *rand.Rand, a int, b int) int {
+	if r.Intn(2) == 0 {
+		return a
+	}
+	return b
+}
+
+// A response time in seconds, mostly fast with a long tail
+func presetLatency(r *rand.Rand) float64 {
+	return min(0.001+r.ExpFloat64()*0.05, 30)
+}
+
+// A request line, status and response size
+func presetRequest(r *rand.Rand) (string, int, int) {
+	status := presetStatus(r)
+	size := 0
+	if status != 204 && status != 304 {
+		size = 100 + r.Intn(20000)
+	}
+	return fmt.Sprintf("%v %v HTTP/1.1", presetPick(r, presetMethods), presetPick(r, presetPaths)), status, size
+}
+
+// nginx's "combined" log_format followed by $request_time, the response time
+// in seconds with millisecond resolution
+func nginxPreset(r *rand.Rand, t time.Time) string {
+	req, status, size := presetRequest(r)
+	return fmt.Sprintf(`%v - %v [%v] "%v" %v %v "%v" "%v" %.3f`, presetIP(r), presetPick(r, presetUsers),
+		t.Format("02/Jan/2006:15:04:05 -0700"), req, status, size, presetPick(r, presetReferers), presetPick(r, presetAgents),
+		presetLatency(r))
+}
+
+// Apache's Common Log Format, which writes '-' for an empty response,
+// followed by %D, the response time in microseconds
+func apachePreset(r *rand.Rand, t time.Time) string {
+	req, status, size := presetRequest(r)
+	sizeStr := "-"
+	if size > 0 {
+		sizeStr = fmt.Sprint(size)
+	}
+	return fmt.Sprintf(`%v - %v [%v] "%v" %v %v %v`, presetIP(r), presetPick(r, presetUsers),
+		t.Format("02/Jan/2006:15:04:05 -0700"), req, status, sizeStr, int(presetLatency(r)*1e6))
+}
+
+// A syslog message, its severity and facility. Errors are rarer than info
+func presetSyslog(r *rand.Rand) (string, int, int) {
+	i := r.Intn(len(presetMessages))
+	severity := 6
+	if i >= len(presetMessages)-2 {
+		severity = 3
+	}
+	// user, daemon, auth or local0
+	facility := []int{1, 3, 4, 16}[r.Intn(4)]
+	return presetMessages[i], severity, facility
+}
+
+// RFC 3164 (BSD) syslog: <PRI>Mmm dd hh:mm:ss HOST TAG[PID]: MSG
+func syslog3164Preset(r *rand.Rand, t time.Time) string {
+	msg, severity, facility := presetSyslog(r)
+	return fmt.Sprintf("<%v>%v %v %v[%v]: %v", facility*8+severity, t.Format(time.Stamp),
+		presetPick(r, presetHosts), presetPick(r, presetApps), 100+r.Intn(32000), msg)
+}
+
+// RFC 5424 syslog: <PRI>1 TIMESTAMP HOST APP PROCID MSGID SD MSG
+func syslog5424Preset(r *rand.Rand, t time.Time) string {
+	msg, severity, facility := presetSyslog(r)
+	return fmt.Sprintf(`<%v>1 %v %v %v %v ID%v [meta@32473 requestId="%08x"] %v`, facility*8+severity,
+		t.Format("2006-01-02T15:04:05.000000Z07:00"), presetPick(r, presetHosts), presetPick(r, presetApps),
+		100+r.Intn(32000), r.Intn(100), r.Uint32(), msg)
+}
+
+// Kubernetes klog header: Lmmdd hh:mm:ss.uuuuuu threadid file:line] msg
+func klogPreset(r *rand.Rand, t time.Time) string {
+	msg, severity, _ := presetSyslog(r)
+	l := "I"
+	switch {
+	case severity <= 3:
+		l = "E"
+	case r.Intn(10) == 0:
+		l = "W"
+	}
+	return fmt.Sprintf("%v%v %7d %v:%v] %v", l, t.Format("0102 15:04:05.000000"), 1+r.Intn(100000),
+		presetPick(r, presetKlogFiles), 10+r.Intn(900), msg)
+}
+
+// A JSON access log line like a typical web app writes
+func jsonAppPreset(r *rand.Rand, t time.Time) string {
+	method, path := presetPick(r, presetMethods), presetPick(r, presetPaths)
+	status := presetStatus(r)
+	level := "info"
+	switch {
+	case status >= 500:
+		level = "error"
+	case status >= 400:
+		level = "warn"
+	}
+
+	buf := &bytes.Buffer{}
+	o := newJsonObject(buf)
+	o.field("time", t.UTC().Format(time.RFC3339Nano))
+	o.field("level", level)
+	o.field("msg", "request completed")
+	o.field("method", method)
+	o.field("path", path)
+	o.field("status", status)
+	o.field("latency_ms", float64(int(presetLatency(r)*1e5))/100)
+	o.field("client_ip", presetIP(r))
+	o.field("user_agent", presetPick(r, presetAgents))
+	o.field("request_id", fmt.Sprintf("%08x%08x", r.Uint32(), r.Uint32()))
+	o.close()
+	return buf.String()
+}
+
+// Whether any of a stream's interpolations use a preset
+func usesPresets(defs []interpolation) bool {
+	return slices.ContainsFunc(defs, func(i interpolation) bool {
+		return i.interpolator == string(interpolatorEnumPreset)
+	})
+}
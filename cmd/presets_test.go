@@ -0,0 +1,72 @@
+/*
+Copyright © 2023 Ben Orgil
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+)
+
+func (ts *ExecTestSuite) TestPresets() {
+	for preset, re := range map[string]string{
+		"nginx":      `^\d+\.\d+\.\d+\.\d+ - \S+ \[\d{2}/\w{3}/\d{4}:\d{2}:\d{2}:\d{2} [+-]\d{4}\] "[A-Z]+ \S+ HTTP/1\.1" \d{3} \d+ "\S+" "[^"]+" \d+\.\d{3}$`,
+		"apache":     `^\d+\.\d+\.\d+\.\d+ - \S+ \[\d{2}/\w{3}/\d{4}:\d{2}:\d{2}:\d{2} [+-]\d{4}\] "[A-Z]+ \S+ HTTP/1\.1" \d{3} (\d+|-) \d+$`,
+		"syslog3164": `^<\d{1,3}>\w{3} [ \d]\d \d{2}:\d{2}:\d{2} \S+ \w+\[\d+\]: .+$`,
+		"syslog5424": `^<\d{1,3}>1 \d{4}-\d{2}-\d{2}T\S+ \S+ \w+ \d+ ID\d+ \[meta@32473 requestId="[0-9a-f]{8}"\] .+$`,
+		"klog":       `^[IWE]\d{4} \d{2}:\d{2}:\d{2}\.\d{6} +\d+ \w+\.go:\d+\] .+$`,
+	} {
+		cmd, err := ts.ExecuteCmd([]string{"--stdout=__I__", "--repeat=20", "--repeat_interval=0", "--interpolator=preset",
+			"--interpolate_val=" + preset, "--output_format=raw", "--seed=5"})
+		ts.Require().NoError(err)
+		lines := strings.Split(strings.TrimSuffix(cmd.RawStdOut, "\n"), "\n")
+		ts.Len(lines, 20)
+		for _, l := range lines {
+			ts.Regexp(regexp.MustCompile(re), l, preset)
+		}
+	}
+
+	cmd, err := ts.ExecuteCmd([]string{"--stdout=__I__", "--repeat=5", "--repeat_interval=0", "--interpolator=preset",
+		"--interpolate_val=json_app", "--output_format=raw"})
+	ts.Require().NoError(err)
+	for _, l := range strings.Split(strings.TrimSuffix(cmd.RawStdOut, "\n"), "\n") {
+		m := map[string]any{}
+		ts.Require().NoError(json.Unmarshal([]byte(l), &m))
+		ts.Contains(m, "status")
+		ts.Contains(m, "latency_ms")
+		ts.Contains(m, "client_ip")
+	}
+}
+
+func (ts *ExecTestSuite) TestPresetsSeed() {
+	args := []string{"--stdout=web1 __LOG__", "--stderr=__LOG__", "--repeat=10", "--repeat_interval=0",
+		"--interpolate=__LOG__=preset:nginx", "--output_format=raw", "--seed=9"}
+	first, err := ts.ExecuteCmd(args)
+	ts.Require().NoError(err)
+	ts.True(strings.HasPrefix(first.RawStdOut, "web1 "))
+
+	// Lines only differ by their timestamps
+	stripTime := regexp.MustCompile(`\[[^\]]+\]`)
+	second, err := ts.ExecuteCmd(args)
+	ts.Require().NoError(err)
+	ts.Equal(stripTime.ReplaceAllString(first.RawStdOut, ""), stripTime.ReplaceAllString(second.RawStdOut, ""))
+	// The streams get different lines
+	ts.NotEqual(stripTime.ReplaceAllString(first.RawStdErr, ""), stripTime.ReplaceAllString(strings.ReplaceAll(first.RawStdOut, "web1 ", ""), ""))
+
+	_, err = ts.ExecuteCmd([]string{"--stdout=__I__", "--interpolator=preset", "--interpolate_val=iis"})
+	ts.ErrorAs(err, new(*paramSetValidationError))
+}
@@ -0,0 +1,110 @@
+/*
+Copyright © 2023 Ben Orgil
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"hash/fnv"
+	"math"
+	"math/rand"
+	"time"
+)
+
+/*
+Shapes the output of a stream by deciding how many lines each tick of the
+scheduler writes and how long to wait until the next tick:
+
+  - interval: 1 line every repeat_interval (the default)
+  - lines_per_sec: 1 line every 1/rate seconds
+  - bytes_per_sec: waits as long as the bytes written take at rate bytes/sec
+  - burst: burst_size lines back to back every repeat_interval
+  - uniform: 1 line every repeat_interval +/- a random jitter
+  - poisson: every repeat_interval a Poisson distributed number of lines
+    averaging rate lines
+  - exponential: 1 line after exponentially distributed gaps averaging
+    repeat_interval (ie arrivals of a Poisson process)
+
+The random modes use a generator seeded from --seed so runs can be reproduced.
+*/
+type rateShaper struct {
+	mode      rateModeEnum
+	interval  time.Duration
+	rate      float64
+	burstSize int
+	jitter    time.Duration
+	rand      *rand.Rand
+}
+
+func newRateShaper(args viperArgs, stream string) *rateShaper {
+	return &rateShaper{
+		mode:      rateModeEnum(args.rateMode),
+		interval:  args.repeatInterval,
+		rate:      args.rate,
+		burstSize: args.burstSize,
+		jitter:    args.jitter,
+		rand:      newStreamRand(args.seed, stream),
+	}
+}
+
+// Random generator for a stream. Each stream gets its own generator since
+// they run in separate goroutines, derived from the seed so they differ
+func newStreamRand(seed int64, stream string) *rand.Rand {
+	h := fnv.New64a()
+	h.Write([]byte(stream))
+	return rand.New(rand.NewSource(seed ^ int64(h.Sum64())))
+}
+
+// Number of lines to write on this tick
+func (r *rateShaper) lines() int {
+	switch r.mode {
+	case rateModeEnumBurst:
+		return r.burstSize
+	case rateModeEnumPoisson:
+		return r.poisson(r.rate)
+	default:
+		return 1
+	}
+}
+
+// Time until the next tick, given the bytes written on this tick
+func (r *rateShaper) gap(bytes int) time.Duration {
+	switch r.mode {
+	case rateModeEnumLinesPerSec:
+		return time.Duration(float64(time.Second) / r.rate)
+	case rateModeEnumBytesPerSec:
+		return time.Duration(float64(bytes) * float64(time.Second) / r.rate)
+	case rateModeEnumUniform:
+		d := r.interval - r.jitter + time.Duration(r.rand.Int63n(int64(2*r.jitter)+1))
+		return max(d, 0)
+	case rateModeEnumExponential:
+		return time.Duration(r.rand.ExpFloat64() * float64(r.interval))
+	default:
+		return r.interval
+	}
+}
+
+// Knuth's algorithm for small means, a normal approximation for large ones
+func (r *rateShaper) poisson(mean float64) int {
+	if mean > 30 {
+		return max(0, int(math.Round(mean+math.Sqrt(mean)*r.rand.NormFloat64())))
+	}
+	l := math.Exp(-mean)
+	k := 0
+	for p := r.rand.Float64(); p > l; p *= r.rand.Float64() {
+		k++
+	}
+	return k
+}
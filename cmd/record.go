@@ -0,0 +1,258 @@
+/*
+Copyright © 2023 Ben Orgil
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+/*
+A capture of a real command's run, written by `et record` and played back by
+`et replay`. Output is kept as the raw chunks the command wrote, with the
+time since the start of the run each one arrived at, so a replay has the same
+bursts, pauses and partial lines as the original:
+
+	{
+	  "command": ["./flaky", "--serve"],
+	  "exit_code": 3,
+	  "duration": 2500000000,
+	  "events": [
+	    {"offset": 1200000, "stream": "stdout", "data": "c3RhcnRpbmcK"},
+	    {"offset": 2000000000, "stream": "signal", "signal": "SIGTERM"}
+	  ]
+	}
+
+Data is base64 so binary output and broken UTF-8 survive byte for byte.
+Offsets and the duration are nanoseconds.
+*/
+type capture struct {
+	Command  []string      `json:"command"`
+	Started  time.Time     `json:"started"`
+	Duration time.Duration `json:"duration"`
+	// For a command killed by a signal this is 128+signal like a shell reports
+	ExitCode int            `json:"exit_code"`
+	Signal   string         `json:"signal,omitempty"`
+	Events   []captureEvent `json:"events"`
+}
+
+// One chunk of output, or a signal that was received and forwarded
+type captureEvent struct {
+	Offset time.Duration `json:"offset"`
+	// stdout, stderr or signal
+	Stream string `json:"stream"`
+	Data   []byte `json:"data,omitempty"`
+	Signal string `json:"signal,omitempty"`
+}
+
+const captureEventSignal = "signal"
+
+// How long the output is still recorded after the command exits
+const recordDrainTimeout = time.Second
+
+func readCapture(file string) (capture, error) {
+	var c capture
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return c, err
+	}
+	if err := json.Unmarshal(b, &c); err != nil {
+		return c, fmt.Errorf("'%v' is not a capture file: %v", file, err.Error())
+	}
+	return c, nil
+}
+
+func writeCapture(file string, c capture) error {
+	b, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(file, append(b, '\n'), 0644)
+}
+
+// Collects the events of a recording. Output is read from two goroutines
+// and signals from a third so appending is locked
+type recorder struct {
+	mu     sync.Mutex
+	start  time.Time
+	events []captureEvent
+}
+
+func (r *recorder) add(e captureEvent) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	e.Offset = time.Since(r.start)
+	r.events = append(r.events, e)
+}
+
+// Read a pipe of the command until it closes. Every chunk is recorded and
+// also passed through so you still see the output while recording
+func (r *recorder) capture(stream string, pipe io.Reader, passthrough io.Writer) {
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := pipe.Read(buf)
+		if n > 0 {
+			r.add(captureEvent{Stream: stream, Data: append([]byte(nil), buf[:n]...)})
+			passthrough.Write(buf[:n])
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// Run a command, recording its output, the signals sent to it and how it
+// exited. Returns the command's exit code as an *ExitError
+func record(cmd *cobra.Command, v *viper.Viper, command []string) error {
+	bindEnvToFlags(v)
+	file := v.GetString("record.capture_file")
+	if file == "" {
+		return &paramSetValidationError{"capture_file can't be empty"}
+	}
+
+	c := exec.Command(command[0], command[1:]...)
+	setProcessGroup(c)
+	stdoutPipe, err := c.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	stderrPipe, err := c.StderrPipe()
+	if err != nil {
+		return err
+	}
+
+	// Forward signals to the command. SIGPIPE is left alone since it's about
+	// our own output, not something the command should see. They're caught
+	// before the command starts so one sent right after it starts is forwarded
+	sigs := make(chan os.Signal, 1)
+	for name, s := range signalNames {
+		if name != "SIGPIPE" {
+			signal.Notify(sigs, s)
+		}
+	}
+
+	r := &recorder{start: time.Now()}
+	if err := c.Start(); err != nil {
+		signal.Stop(sigs)
+		return err
+	}
+
+	forwarded := make(chan struct{})
+	go func() {
+		defer close(forwarded)
+		for s := range sigs {
+			r.add(captureEvent{Stream: captureEventSignal, Signal: signalName(s)})
+			c.Process.Signal(s)
+		}
+	}()
+
+	drained := make(chan struct{})
+	go func() {
+		defer close(drained)
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			r.capture("stdout", stdoutPipe, cmd.OutOrStdout())
+		}()
+		go func() {
+			defer wg.Done()
+			r.capture("stderr", stderrPipe, cmd.ErrOrStderr())
+		}()
+		wg.Wait()
+	}()
+
+	// Waiting on the process instead of c.Wait() gives the time it exited,
+	// even if a child of it still holds the pipes
+	state, err := c.Process.Wait()
+	duration := time.Since(r.start)
+	// Stop forwarding, there's no one left to forward to
+	signal.Stop(sigs)
+	close(sigs)
+	<-forwarded
+
+	// A child holding the pipes, ie et --grandchild_hold, would keep the
+	// recording going until it exits. Its output is only recorded for a while
+	select {
+	case <-drained:
+	case <-time.After(recordDrainTimeout):
+	}
+	stdoutPipe.Close()
+	stderrPipe.Close()
+	<-drained
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	result := capture{
+		Command:  command,
+		Started:  r.start,
+		Duration: duration,
+		ExitCode: state.ExitCode(),
+		Events:   r.events,
+	}
+	r.mu.Unlock()
+	if ws, ok := state.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+		result.Signal = signalName(ws.Signal())
+		result.ExitCode = 128 + int(ws.Signal())
+	}
+
+	if err := writeCapture(file, result); err != nil {
+		return err
+	}
+
+	return newExitError(result.ExitCode, ExitCauseCommand)
+}
+
+func recordCmd(v *viper.Viper) *cobra.Command {
+	recordCmd := &cobra.Command{
+		Use:   "record [flags] -- command [args...]",
+		Short: "Record a command's output, signals and exit code to a capture file",
+		Long: `Runs a command and records every chunk it writes to stdout and stderr,
+when it was written, the signals sent to it and its exit code into a
+capture file that 'et replay' can play back. Signals sent to 'et record'
+are forwarded to the command. The output is passed through while recording.
+Output written after the command exits, by a child of it still holding its
+stdout or stderr, is only recorded for a second.
+
+Example Usage
+-------------
+Record a command to flaky.json:
+$ et record --capture_file=flaky.json -- ./flaky --serve
+`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return silenceExit(cmd, validationExitError(record(cmd, v, args)))
+		},
+	}
+
+	recordCmd.Flags().String("capture_file", "et_capture.json", "File to write the capture to")
+	v.BindPFlag("record.capture_file", recordCmd.Flags().Lookup("capture_file"))
+
+	return recordCmd
+}
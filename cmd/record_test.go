@@ -0,0 +1,99 @@
+//go:build unix
+
+/*
+Copyright © 2023 Ben Orgil
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+func (ts *ExecTestSuite) TestRecordReplay() {
+	f := filepath.Join(ts.T().TempDir(), "capture.json")
+
+	// Output is passed through while recording
+	cmd, err := ts.ExecuteCmd([]string{"record", "--capture_file=" + f, "--",
+		"sh", "-c", "printf 'one\\n'; sleep 0.4; printf 'two' >&2"})
+	ts.Require().NoError(err)
+	ts.Equal([]string{"one"}, cmd.StdOut)
+	ts.Equal([]string{"two"}, cmd.StdErr)
+
+	c, err := readCapture(f)
+	ts.Require().NoError(err)
+	ts.Equal(0, c.ExitCode)
+	ts.Require().Len(c.Events, 2)
+	ts.Equal(captureEvent{Offset: c.Events[0].Offset, Stream: "stdout", Data: []byte("one\n")}, c.Events[0])
+	ts.Equal(captureEvent{Offset: c.Events[1].Offset, Stream: "stderr", Data: []byte("two")}, c.Events[1])
+	ts.GreaterOrEqual(c.Events[1].Offset, 400*time.Millisecond)
+
+	// Through the output formatter the partial line is flushed at the end
+	cmd, err = ts.ExecuteCmd([]string{"replay", f})
+	ts.Require().NoError(err)
+	ts.True(cmd.IsJson)
+	ts.Equal([]string{"one"}, cmd.StdOut)
+	ts.Equal([]string{"two"}, cmd.StdErr)
+
+	// Twice at 4x speed, byte for byte
+	now := time.Now()
+	cmd, err = ts.ExecuteCmd([]string{"replay", "--raw", "--speed=4", "--loop=2", f})
+	ts.Require().NoError(err)
+	ts.GreaterOrEqual(time.Since(now), 200*time.Millisecond)
+	ts.Less(time.Since(now), 800*time.Millisecond)
+	ts.Equal([]string{"one", "one"}, cmd.StdOut)
+	ts.Equal([]string{"twotwo"}, cmd.StdErr)
+
+	_, err = ts.ExecuteCmd([]string{"replay", "--speed=0", f})
+	ts.ErrorAs(err, new(*paramSetValidationError))
+}
+
+func (ts *ExecTestSuite) TestRecordHeldPipes() {
+	f := filepath.Join(ts.T().TempDir(), "capture.json")
+
+	// sleep holds stdout long after the shell exits
+	now := time.Now()
+	cmd, err := ts.ExecuteCmd([]string{"record", "--capture_file=" + f, "--", "sh", "-c", "echo one; sleep 10 &"})
+	ts.Require().NoError(err)
+	ts.Less(time.Since(now), recordDrainTimeout+2*time.Second)
+	ts.Equal([]string{"one"}, cmd.StdOut)
+
+	c, err := readCapture(f)
+	ts.Require().NoError(err)
+	ts.Equal(0, c.ExitCode)
+	ts.Less(c.Duration, recordDrainTimeout)
+}
+
+func (ts *ExecTestSuite) TestRecordSignal() {
+	f := filepath.Join(ts.T().TempDir(), "capture.json")
+
+	// The command says when its trap is set. sleep doesn't hold the pipes so
+	// the recording ends with the shell
+	p := ts.startEt(10*time.Second, "record", "--capture_file="+f, "--",
+		"sh", "-c", "trap 'echo got; exit 0' USR1; echo ready; sleep 5 >/dev/null 2>&1 & wait")
+	ts.Require().True(p.waitFor("ready"))
+	ts.Require().NoError(p.cmd.Process.Signal(syscall.SIGUSR1))
+	ts.Require().True(p.wait(), "timed out")
+	ts.Equal(0, p.cmd.ProcessState.ExitCode())
+	ts.Equal("ready\ngot\n", p.stdout.String())
+
+	c, err := readCapture(f)
+	ts.Require().NoError(err)
+	ts.Require().Len(c.Events, 3)
+	ts.Equal("SIGUSR1", c.Events[1].Signal)
+	ts.Equal(captureEvent{Offset: c.Events[2].Offset, Stream: "stdout", Data: []byte("got\n")}, c.Events[2])
+}
@@ -0,0 +1,185 @@
+/*
+Copyright © 2023 Ben Orgil
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// Holds all the viper args of the replay subcommand
+type replayArgs struct {
+	outputFormatter OutputFormatter
+	speed           float64
+	loop            int
+	loopForever     bool
+	raw             bool
+}
+
+/*
+Writes the output of a capture. In raw mode the chunks are written exactly as
+they were recorded. Otherwise they are split into lines which are each sent
+through the OutputFormatter like --stdout/--stderr text. A line split across
+chunks is held until the rest of it arrives.
+*/
+type replayWriter struct {
+	cmd     *cobra.Command
+	args    replayArgs
+	partial map[string]*bytes.Buffer
+}
+
+func (w *replayWriter) write(stream string, data []byte) {
+	if w.args.raw {
+		if stream == "stdout" {
+			w.cmd.OutOrStdout().Write(data)
+		} else {
+			w.cmd.ErrOrStderr().Write(data)
+		}
+		return
+	}
+
+	b, ok := w.partial[stream]
+	if !ok {
+		b = &bytes.Buffer{}
+		w.partial[stream] = b
+	}
+	b.Write(data)
+	for {
+		i := bytes.IndexByte(b.Bytes(), '\n')
+		if i < 0 {
+			return
+		}
+		line := string(b.Next(i + 1))
+		w.writeLine(stream, strings.TrimRight(line, "\r\n"))
+	}
+}
+
+func (w *replayWriter) writeLine(stream string, line string) {
+	if stream == "stdout" {
+		w.args.outputFormatter.cobraStdout(context.Background(), w.cmd, line, slog.LevelInfo)
+	} else {
+		w.args.outputFormatter.cobraStderr(context.Background(), w.cmd, line, slog.LevelInfo)
+	}
+}
+
+// Write out lines that never got their newline
+func (w *replayWriter) flush() {
+	for _, stream := range []string{"stdout", "stderr"} {
+		if b, ok := w.partial[stream]; ok && b.Len() > 0 {
+			w.writeLine(stream, b.String())
+			b.Reset()
+		}
+	}
+}
+
+// Play a capture file. Every event is scheduled from the start of the loop
+// (offset/speed) so slow writes don't add up. Returns the recorded exit code
+// as an *ExitError
+func replay(cmd *cobra.Command, v *viper.Viper, file string, fallbackLogger *slog.Logger) error {
+	bindEnvToFlags(v)
+
+	args := replayArgs{
+		outputFormatter: getOutputFormatter(v, fallbackLogger),
+		speed:           v.GetFloat64("replay.speed"),
+		loop:            v.GetInt("replay.loop"),
+		loopForever:     v.GetBool("replay.loop_forever"),
+		raw:             v.GetBool("replay.raw"),
+	}
+	switch {
+	case args.speed <= 0:
+		return &paramSetValidationError{"speed must be > 0"}
+	case args.loop < 0:
+		return &paramSetValidationError{"loop can't be negative"}
+	}
+	logger := args.outputFormatter
+
+	c, err := readCapture(file)
+	if err != nil {
+		return err
+	}
+
+	scale := func(d time.Duration) time.Duration {
+		return time.Duration(float64(d) / args.speed)
+	}
+
+	w := &replayWriter{cmd: cmd, args: args, partial: map[string]*bytes.Buffer{}}
+	for loop := 0; args.loopForever || loop < args.loop; loop++ {
+		start := time.Now()
+		for _, e := range c.Events {
+			time.Sleep(time.Until(start.Add(scale(e.Offset))))
+			switch e.Stream {
+			case "stdout", "stderr":
+				w.write(e.Stream, e.Data)
+			case captureEventSignal:
+				// Logging would break the byte for byte output of raw mode
+				if !args.raw {
+					logger.Logger.Info(fmt.Sprintf("Recorded command received '%v' at '%v'", e.Signal, e.Offset))
+				}
+			}
+		}
+		w.flush()
+		// Keep the time between the last output and the exit
+		time.Sleep(time.Until(start.Add(scale(c.Duration))))
+	}
+
+	return newExitError(c.ExitCode, ExitCauseCommand)
+}
+
+func replayCmd(v *viper.Viper, fallbackLogger *slog.Logger) *cobra.Command {
+	replayCmd := &cobra.Command{
+		Use:   "replay [flags] capture_file",
+		Short: "Replay a capture file written by 'et record'",
+		Long: `Plays back the output of a capture file written by 'et record' with the
+same timing and then exits with the recorded exit code. By default each
+line is sent through the --output_format like --stdout/--stderr text.
+With --raw the output is written byte for byte as it was recorded.
+
+Example Usage
+-------------
+Replay a capture at twice the speed:
+$ et replay --speed=2 flaky.json
+
+Replay a capture byte for byte 3 times in a row:
+$ et replay --raw --loop=3 flaky.json
+`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return silenceExit(cmd, validationExitError(replay(cmd, v, args[0], fallbackLogger)))
+		},
+	}
+
+	replayCmd.Flags().Float64("speed", 1, "Speed factor, ie '2' plays twice as fast and '0.5' half as fast")
+	v.BindPFlag("replay.speed", replayCmd.Flags().Lookup("speed"))
+
+	replayCmd.Flags().Int("loop", 1, "Number of times to play the capture")
+	v.BindPFlag("replay.loop", replayCmd.Flags().Lookup("loop"))
+
+	replayCmd.Flags().Bool("loop_forever", false, "Play the capture over and over")
+	v.BindPFlag("replay.loop_forever", replayCmd.Flags().Lookup("loop_forever"))
+
+	replayCmd.Flags().Bool("raw", false, "Write the recorded bytes as is instead of through the output_format")
+	v.BindPFlag("replay.raw", replayCmd.Flags().Lookup("raw"))
+
+	return replayCmd
+}
@@ -19,31 +19,13 @@ package cmd
 import (
 	"log/slog"
 	"os"
+	"strings"
 
 	"github.com/benorgil/exectester/configs"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
 
-// Flags
-var (
-	cfgFile        string
-	stdout         string
-	stderr         string
-	socket         string
-	socketSend     string
-	readSocket     bool
-	socketExitMsg  string
-	exitcode       int
-	repeat         int
-	repeatInterval int
-	repeatForever  bool
-	timeout        int
-	sigtermTimeout int
-	interpolateKey string
-	interpolateVal string
-)
-
 // If setting config with env vars they must be prefixed with this string
 const (
 	EnvPrefix string = "ET_"
@@ -51,6 +33,11 @@ const (
 
 // rootCmd represents the base command when called without any subcommands
 func RootCmd(fallbackLogger *slog.Logger) *cobra.Command {
+	// Every command gets its own viper and flags so several can run in one
+	// process, ie in parallel tests
+	v := viper.New()
+	v.SetEnvPrefix(EnvPrefix)
+	var cfgFile string
 
 	rootCmd := &cobra.Command{
 		Use:   "et",
@@ -59,6 +46,13 @@ func RootCmd(fallbackLogger *slog.Logger) *cobra.Command {
 and also set the exit code. It supports dynamically generating output
 which can also be interpolated with other values in various ways.
 
+Per stream settings
+-------------------
+The repeat, timing, rate, interpolation, level, stack trace, message, file, data set, sequence and partial write flags
+apply to every stream. Each of them can be overridden for a single stream by prefixing the flag with
+'stdout_', 'stderr_' or 'socket_' (ie --stderr_repeat_interval=5) or in a
+'streams' block of the config file.
+
 Example Usage
 -------------
 Send to stdout and stderr:
@@ -76,109 +70,366 @@ $ et --stdout='stdout counter: __I__' --stderr='stderr counter: __I__' --repeat=
 Send to stdout and stderr 3 times and interpolate __I__ with a string 'zzz':
 $ et --stdout='stdout counter: __I__' --stderr='stderr counter: __I__' --repeat=3 --interpolator=string --interpolate_val=zzz
 
+Send to stdout 3 times interpolating several keys at once:
+$ et --stdout='__TS__ n=__N__ host=__HOST__' --repeat=3 --interpolate=__N__=int_counter:100 --interpolate=__HOST__=string:web1 --interpolate=__TS__=timestamp:rfc3339
+
+Send to stdout 3 times rendering it as a Go text/template each time:
+$ et --stdout='{{now.Format "15:04:05"}} {{.Stream}}[{{.Pid}}] id={{uuid}} n={{pad 3 .Counter}}' --repeat=3 --interpolator=template
+
+Send 100 synthetic nginx access log lines to stdout (also apache, syslog3164, syslog5424, klog and json_app):
+$ et --stdout=__I__ --repeat=100 --repeat_interval=0 --interpolator=preset --interpolate_val=nginx --output_format=raw --seed=42
+
+Send to stdout every second and stderr every 5 seconds for 30 seconds:
+$ et --stdout='o' --stderr='e' --repeat_forever --timeout=30 --stderr_repeat_interval=5
+
+Send to stdout 4 times a second for 10 seconds:
+$ et --stdout='stdout counter: __I__' --repeat_forever --repeat_interval=250ms --timeout=10s
+
+Send to stdout at 1000 lines/sec for 10 seconds:
+$ et --stdout='line __I__' --repeat_forever --rate_mode=lines_per_sec --rate=1000 --timeout=10s
+
+Send to stdout with random Poisson arrivals averaging 5 lines every 100ms, reproducible with a seed:
+$ et --stdout='line __I__' --repeat_forever --rate_mode=poisson --rate=5 --repeat_interval=100ms --seed=42
+
 Send to stdout for 5 seconds:
 $ et --stdout='stdout counter: __I__' --repeat_forever --timeout=5
 
+Send exactly 'a<TAB>b' to stdout 3 times separated by NUL bytes, no JSON or 'msg=':
+$ et --stdout='a\tb' --repeat=3 --repeat_interval=0 --output_format=raw --line_terminator=nul --decode_escapes
+
+Send to stdout as logfmt (also 'ecs', 'gelf' and 'otel'):
+$ et --stdout='sending to stdout' --output_format=logfmt
+
+Send to stdout formatted by your own template:
+$ et --stdout='sending to stdout' --output_format=template --output_template='[{{.Level}}] {{.Time.Unix}} {{.Msg}}'
+
+Send to stdout with extra fields, shaped like another service's logs:
+$ et --stdout='request done' --field=service=api --field=req_id='{{uuid}}' --field=n=__I__ --rename_field=msg=message --rename_field=time=@timestamp --time_format=unix_ms
+
+Send to stdout at random levels, mostly info, and only errors to stderr:
+$ et --stdout='request __I__' --stderr='failed __I__' --repeat=100 --repeat_interval=10ms --level_distribution=info=80,warn=15,error=5 --stderr_level=error
+
+Send to stderr with a Java stack trace on every third line or so, as multi-line blocks:
+$ et --stderr='order __I__ failed' --repeat=10 --repeat_interval=0 --stack_trace=java --stack_trace_ratio=0.3 --stack_trace_mode=block --output_format=raw
+
+Send to stdout picking one of several messages at random for each line:
+$ et --stdout_messages='user __I__ logged in' --stdout_messages='cache miss' --stdout_messages='GET /healthz 200' --message_selection=random --repeat=10
+
+Send to stderr picking from the lines of a file, '80:text' lines weigh 80 times as much as plain ones:
+$ et --stderr_messages_file=errors.txt --message_selection=weighted --repeat_forever
+
+Send the lines of a log file to stdout as fast as possible, then send them again spaced out like their timestamps:
+$ et --stdout_file=app.log --repeat_interval=0 --output_format=raw
+$ et --stdout_file=app.log --file_pacing --output_format=raw
+
+Send the lines appended to a log file to stderr for a minute, like 'tail -f':
+$ et --stderr_file=app.log --file_follow --repeat_interval=0 --timeout=1m --output_format=raw
+
+Send to stdout taking the values of each line from the next row of a CSV (or JSON lines) file:
+$ et --stdout='__IP__ GET {{.Row.path}}' --interpolator=template --interpolate=__IP__=data_set:client_ip --data_set=samples.csv --repeat=1000 --repeat_interval=0
+
+Send to stdout and stderr in a fixed order, numbering the lines of both together:
+$ et --stdout='o __I__' --stderr='e __I__' --repeat=4 --repeat_interval=0 --interleave=o,e,e,o --interleave_seq --output_format=raw
+
+Send to stdout writing half a line, waiting 2 seconds and then writing the rest, with no newline at the very end:
+$ et --stdout='a slow line' --repeat=3 --write_chunks=2 --write_delay=2s --no_final_newline
+
+Send to stdout and exit with code '3', leaving a detached copy of et holding stdout and stderr open for 30 seconds:
+$ et --stdout='done' --exitcode=3 --grandchild_hold=30s --grandchild_text='still here'
+
+Send to stdout every second, logging SIGHUP, starting over on SIGUSR1 and exiting with '3' on SIGTERM:
+$ et --stdout='line __I__' --repeat_forever --on_signal=SIGHUP=log --on_signal=SIGUSR1=restart --on_signal=SIGTERM=exit:3
+
+Send to stdout every second until SIGTERM, then drain, stop the output and hang 30 seconds past the 5 second grace period before exiting with '143':
+$ et --stdout='working __I__' --repeat_forever --sigterm_timeout=5 --drain_message='draining' --shutdown_stop_output --shutdown_hang=30s --shutdown_exitcode=143
+
+Send to stdout until the 10th line, then die by SIGSEGV like a crashing process:
+$ et --stdout='line __I__' --repeat_forever --crash=SIGSEGV --crash_after_lines=10
+
+Send 1MiB to stderr before anything is sent to stdout, logging how long the writes blocked:
+$ et --flood_stream=stderr --flood_bytes=1MiB --stdout='done'
+
 Send to stdout and stderr and then exit with code '123'
 $ et --stdout='sending to stdout' --stderr='sending to stderr' --exitcode=123
+
+Run the timeline of steps defined under the 'scenario' key of a config file:
+$ et --config=scenario.yaml
+
+Number every line so a log shipper's delivery can be checked for lost, duplicated, reordered or corrupted lines:
+$ et --stdout='line __I__' --repeat=10000 --repeat_interval=1ms --sequence --run_id=test1
+$ et verify --expect=10000 delivered.log
+
+Record a real command and replay it later with the same output, timing and exit code:
+$ et record --capture_file=flaky.json -- ./flaky --serve
+$ et replay flaky.json
 `,
+		// Positional args have always been ignored. Without this cobra
+		// rejects them as unknown subcommands
+		Args: cobra.ArbitraryArgs,
+		// Runs for the subcommands too
+		PersistentPreRun: func(cmd *cobra.Command, args []string) {
+			initConfig(v, cfgFile)
+		},
 		RunE: func(cmd *cobra.Command, args []string) error {
-			if err := ExecTester(cmd, fallbackLogger); err != nil {
-				return err
+			if err := ExecTester(cmd, v, fallbackLogger); err != nil {
+				return silenceExit(cmd, err)
 			}
 			return nil
 		},
 	}
 
-	viper.SetEnvPrefix(EnvPrefix)
-
 	//////// Set flags here
 	//// Supported outputs
-	rootCmd.PersistentFlags().StringVarP(&stdout, "stdout", "o", "", "Text to send to stdout")
-	viper.BindPFlag("stdout", rootCmd.PersistentFlags().Lookup("stdout"))
+	rootCmd.PersistentFlags().StringP("stdout", "o", "", "Text to send to stdout")
+	v.BindPFlag("stdout", rootCmd.PersistentFlags().Lookup("stdout"))
 
-	rootCmd.PersistentFlags().StringVarP(&stderr, "stderr", "e", "", "Text to send to stderr")
-	viper.BindPFlag("stderr", rootCmd.PersistentFlags().Lookup("stderr"))
+	rootCmd.PersistentFlags().StringP("stderr", "e", "", "Text to send to stderr")
+	v.BindPFlag("stderr", rootCmd.PersistentFlags().Lookup("stderr"))
 
-	rootCmd.PersistentFlags().StringVarP(&socket, "socket", "u", "", "Name of unix socket")
-	viper.BindPFlag("socket", rootCmd.PersistentFlags().Lookup("socket"))
+	rootCmd.PersistentFlags().StringP("socket", "u", "", "Name of unix socket")
+	v.BindPFlag("socket", rootCmd.PersistentFlags().Lookup("socket"))
 
-	rootCmd.PersistentFlags().StringVarP(&socketSend, "socket_send", "w", "", "Text to send to unix socket")
-	viper.BindPFlag("socket_send", rootCmd.PersistentFlags().Lookup("socket_send"))
+	rootCmd.PersistentFlags().StringP("socket_send", "w", "", "Text to send to unix socket")
+	v.BindPFlag("socket_send", rootCmd.PersistentFlags().Lookup("socket_send"))
 
-	rootCmd.PersistentFlags().BoolVarP(&readSocket, "read_socket", "q", false, "Poll the unix socket for output")
-	viper.BindPFlag("read_socket", rootCmd.PersistentFlags().Lookup("read_socket"))
+	rootCmd.PersistentFlags().String("stdout_file", "", "File to send to stdout line by line")
+	v.BindPFlag("stdout_file", rootCmd.PersistentFlags().Lookup("stdout_file"))
 
-	rootCmd.PersistentFlags().StringVarP(&socketExitMsg, "socket_exit_msg", "l", "", "Close connection to socket if it returns this text")
-	viper.BindPFlag("socket_exit_msg", rootCmd.PersistentFlags().Lookup("socket_exit_msg"))
+	rootCmd.PersistentFlags().String("stderr_file", "", "File to send to stderr line by line")
+	v.BindPFlag("stderr_file", rootCmd.PersistentFlags().Lookup("stderr_file"))
+
+	rootCmd.PersistentFlags().String("socket_send_file", "", "File to send to unix socket line by line")
+	v.BindPFlag("socket_send_file", rootCmd.PersistentFlags().Lookup("socket_send_file"))
+
+	rootCmd.PersistentFlags().BoolP("read_socket", "q", false, "Poll the unix socket for output")
+	v.BindPFlag("read_socket", rootCmd.PersistentFlags().Lookup("read_socket"))
+
+	rootCmd.PersistentFlags().StringP("socket_exit_msg", "l", "", "Close connection to socket if it returns this text")
+	v.BindPFlag("socket_exit_msg", rootCmd.PersistentFlags().Lookup("socket_exit_msg"))
 
 	//// Custom type flags
 	var outputFormatterEnumDefault = outputFormatterEnumStructured // Default value
 	rootCmd.PersistentFlags().VarP(&outputFormatterEnumDefault, "output_format", "z", outputFormatterEnumValuesInfoMsg)
-	viper.BindPFlag("output_format", rootCmd.PersistentFlags().Lookup("output_format"))
+	v.BindPFlag("output_format", rootCmd.PersistentFlags().Lookup("output_format"))
+
+	rootCmd.PersistentFlags().String("output_template", `{{.Time.Format "2006-01-02T15:04:05.000Z07:00"}} {{.Level}} {{.Msg}}`, "Go text/template for output_format=template. Has .Time, .Level, .Msg and .Attrs")
+	v.BindPFlag("output_template", rootCmd.PersistentFlags().Lookup("output_template"))
+
+	rootCmd.PersistentFlags().StringArray("field", []string{}, "Repeatable 'key=value' field added to every line of output. Values are interpolated and rendered as templates if they contain '{{'")
+	v.BindPFlag("field", rootCmd.PersistentFlags().Lookup("field"))
+
+	rootCmd.PersistentFlags().StringArray("rename_field", []string{}, "Repeatable 'key=new_key' to rename the built-in 'time', 'level' and 'msg' keys. structured and logfmt output only")
+	v.BindPFlag("rename_field", rootCmd.PersistentFlags().Lookup("rename_field"))
+
+	rootCmd.PersistentFlags().String("time_format", "", "Format of the time key: 'unix', 'unix_ms', 'unix_nano', 'rfc3339', 'rfc3339nano' or a Go time layout. structured and logfmt output only")
+	v.BindPFlag("time_format", rootCmd.PersistentFlags().Lookup("time_format"))
+
+	var lineTerminatorEnumDefault = lineTerminatorEnumLf // Default value
+	rootCmd.PersistentFlags().Var(&lineTerminatorEnumDefault, "line_terminator", lineTerminatorEnumValuesInfoMsg)
+	v.BindPFlag("line_terminator", rootCmd.PersistentFlags().Lookup("line_terminator"))
 
 	var interpolatorEnumDefault = interpolatorEnumIntCounter // Default value
 	rootCmd.PersistentFlags().VarP(&interpolatorEnumDefault, "interpolator", "i", interpolatorEnumValuesInfoMsg)
-	viper.BindPFlag("interpolator", rootCmd.PersistentFlags().Lookup("interpolator"))
+	v.BindPFlag("interpolator", rootCmd.PersistentFlags().Lookup("interpolator"))
 
 	//// Rest of flags
-	rootCmd.PersistentFlags().IntVarP(&exitcode, "exitcode", "c", 0, "Exit with this exit code")
-	viper.BindPFlag("exitcode", rootCmd.PersistentFlags().Lookup("exitcode"))
+	rootCmd.PersistentFlags().IntP("exitcode", "c", 0, "Exit with this exit code")
+	v.BindPFlag("exitcode", rootCmd.PersistentFlags().Lookup("exitcode"))
+
+	rootCmd.PersistentFlags().IntP("repeat", "r", 1, "Number of times to repeat output")
+	v.BindPFlag("repeat", rootCmd.PersistentFlags().Lookup("repeat"))
+
+	rootCmd.PersistentFlags().StringP("repeat_interval", "p", "1s", "Time between repeated output, ie '250ms' or '1.5s'. A bare number is seconds")
+	v.BindPFlag("repeat_interval", rootCmd.PersistentFlags().Lookup("repeat_interval"))
+
+	rootCmd.PersistentFlags().StringP("interpolate_key", "k", "__I__", "Substring key to interpolate")
+	v.BindPFlag("interpolate_key", rootCmd.PersistentFlags().Lookup("interpolate_key"))
 
-	rootCmd.PersistentFlags().IntVarP(&repeat, "repeat", "r", 1, "Number of times to repeat output")
-	viper.BindPFlag("repeat", rootCmd.PersistentFlags().Lookup("repeat"))
+	rootCmd.PersistentFlags().StringP("interpolate_val", "v", "", "The value to replace interpolate_key with. The start of the int_counter, the layout of the timestamp or the name of the preset")
+	v.BindPFlag("interpolate_val", rootCmd.PersistentFlags().Lookup("interpolate_val"))
 
-	rootCmd.PersistentFlags().IntVarP(&repeatInterval, "repeat_interval", "p", 1, "Seconds to wait between repeated output")
-	viper.BindPFlag("repeat_interval", rootCmd.PersistentFlags().Lookup("repeat_interval"))
+	rootCmd.PersistentFlags().StringArray("interpolate", []string{}, "Repeatable 'KEY=interpolator:value' to replace KEY in the output. Allowed interpolators: '"+interpolatorEnumValuesStr+"'")
+	v.BindPFlag("interpolate", rootCmd.PersistentFlags().Lookup("interpolate"))
 
-	rootCmd.PersistentFlags().StringVarP(&interpolateKey, "interpolate_key", "k", "__I__", "Substring key to interpolate")
-	viper.BindPFlag("interpolate_key", rootCmd.PersistentFlags().Lookup("interpolate_key"))
+	rootCmd.PersistentFlags().BoolP("repeat_forever", "f", false, "Run forever")
+	v.BindPFlag("repeat_forever", rootCmd.PersistentFlags().Lookup("repeat_forever"))
 
-	rootCmd.PersistentFlags().StringVarP(&interpolateVal, "interpolate_val", "v", "", "The value to replace interpolate_key with")
-	viper.BindPFlag("interpolate_val", rootCmd.PersistentFlags().Lookup("interpolate_val"))
+	rootCmd.PersistentFlags().StringP("timeout", "t", "0", "Exits when timeout exceeded, ie '500ms' or '5m'. A bare number is seconds. '0' means no timeout set")
+	v.BindPFlag("timeout", rootCmd.PersistentFlags().Lookup("timeout"))
 
-	rootCmd.PersistentFlags().BoolVarP(&repeatForever, "repeat_forever", "f", false, "Run forever")
-	viper.BindPFlag("repeat_forever", rootCmd.PersistentFlags().Lookup("repeat_forever"))
+	var rateModeEnumDefault = rateModeEnumInterval // Default value
+	rootCmd.PersistentFlags().Var(&rateModeEnumDefault, "rate_mode", rateModeEnumValuesInfoMsg)
+	v.BindPFlag("rate_mode", rootCmd.PersistentFlags().Lookup("rate_mode"))
 
-	rootCmd.PersistentFlags().IntVarP(&timeout, "timeout", "t", 0, "Exits when timeout (seconds) exceeded. '0' means no timeout set")
-	viper.BindPFlag("timeout", rootCmd.PersistentFlags().Lookup("timeout"))
+	rootCmd.PersistentFlags().Float64("rate", 0, "Lines/sec for rate_mode=lines_per_sec, bytes/sec for bytes_per_sec or mean lines per repeat_interval for poisson")
+	v.BindPFlag("rate", rootCmd.PersistentFlags().Lookup("rate"))
 
-	rootCmd.PersistentFlags().IntVarP(&sigtermTimeout, "sigterm_timeout", "x", 0, "If a sigterm is caught while running wait for X seconds because exiting")
-	viper.BindPFlag("sigterm_timeout", rootCmd.PersistentFlags().Lookup("sigterm_timeout"))
+	rootCmd.PersistentFlags().Int("burst_size", 0, "Lines written back to back every repeat_interval for rate_mode=burst")
+	v.BindPFlag("burst_size", rootCmd.PersistentFlags().Lookup("burst_size"))
+
+	rootCmd.PersistentFlags().String("jitter", "0", "Max random +/- added to repeat_interval for rate_mode=uniform, ie '100ms'")
+	v.BindPFlag("jitter", rootCmd.PersistentFlags().Lookup("jitter"))
+
+	rootCmd.PersistentFlags().Int64("seed", 0, "Seed for anything random so runs can be reproduced. '0' picks a random seed")
+	v.BindPFlag("seed", rootCmd.PersistentFlags().Lookup("seed"))
+
+	rootCmd.PersistentFlags().Bool("sequence", false, "Add the run_id, a sequence number and a checksum to every line so 'et verify' can find lost, duplicated, reordered or corrupted lines")
+	v.BindPFlag("sequence", rootCmd.PersistentFlags().Lookup("sequence"))
+
+	rootCmd.PersistentFlags().String("run_id", "", "ID of the run added to the lines by --sequence. Random if not set")
+	v.BindPFlag("run_id", rootCmd.PersistentFlags().Lookup("run_id"))
+
+	rootCmd.PersistentFlags().String("interleave", "", "Write stdout and stderr from one loop in this order, ie 'o,e,e,o', or 'random' to pick at random with the seed")
+	v.BindPFlag("interleave", rootCmd.PersistentFlags().Lookup("interleave"))
+
+	rootCmd.PersistentFlags().Bool("interleave_seq", false, "Number the lines of stdout and stderr together with a 'gseq' to check the order they were merged in")
+	v.BindPFlag("interleave_seq", rootCmd.PersistentFlags().Lookup("interleave_seq"))
+
+	rootCmd.PersistentFlags().Int("write_chunks", 1, "Split every line into this many writes, write_delay apart")
+	v.BindPFlag("write_chunks", rootCmd.PersistentFlags().Lookup("write_chunks"))
+
+	rootCmd.PersistentFlags().String("write_delay", "100ms", "Time between the writes of a line for write_chunks and trickle")
+	v.BindPFlag("write_delay", rootCmd.PersistentFlags().Lookup("write_delay"))
+
+	rootCmd.PersistentFlags().Bool("trickle", false, "Write every line one byte at a time, write_delay apart")
+	v.BindPFlag("trickle", rootCmd.PersistentFlags().Lookup("trickle"))
+
+	rootCmd.PersistentFlags().Bool("no_final_newline", false, "Leave the line terminator off the last line of the stream")
+	v.BindPFlag("no_final_newline", rootCmd.PersistentFlags().Lookup("no_final_newline"))
+
+	rootCmd.PersistentFlags().String("grandchild_hold", "0", "Start a detached copy of et that inherits stdout and stderr and holds them open for this long, even after et exits. '0' means none. Needs grandchild_exe when et is embedded in another program")
+	v.BindPFlag("grandchild_hold", rootCmd.PersistentFlags().Lookup("grandchild_hold"))
+
+	rootCmd.PersistentFlags().String("grandchild_text", "", "Text the grandchild writes to stdout and stderr every repeat_interval while it holds them. Empty just holds them")
+	v.BindPFlag("grandchild_text", rootCmd.PersistentFlags().Lookup("grandchild_text"))
+
+	rootCmd.PersistentFlags().String("grandchild_exe", "", "Path of the et binary started for grandchild_hold. Defaults to the running executable when it's et")
+	v.BindPFlag("grandchild_exe", rootCmd.PersistentFlags().Lookup("grandchild_exe"))
+
+	rootCmd.PersistentFlags().String("flood_stream", "", "Write flood_bytes to this stream, stdout or stderr, before anything else is written")
+	v.BindPFlag("flood_stream", rootCmd.PersistentFlags().Lookup("flood_stream"))
+
+	rootCmd.PersistentFlags().String("flood_bytes", "1MiB", "Number of bytes to flood with, ie '64KiB', '1MiB' or '4096'")
+	v.BindPFlag("flood_bytes", rootCmd.PersistentFlags().Lookup("flood_bytes"))
+
+	rootCmd.PersistentFlags().String("flood_chunk", "4KiB", "Size of the lines the flood is written in")
+	v.BindPFlag("flood_chunk", rootCmd.PersistentFlags().Lookup("flood_chunk"))
+
+	rootCmd.PersistentFlags().String("flood_warn_after", "1s", "Warn when a write has been blocked this long while flooding. '0' to never warn")
+	v.BindPFlag("flood_warn_after", rootCmd.PersistentFlags().Lookup("flood_warn_after"))
+
+	rootCmd.PersistentFlags().Bool("decode_escapes", false, "Decode escape sequences like '\\t', '\\x1b' or '\\u00e9' in the output text")
+	v.BindPFlag("decode_escapes", rootCmd.PersistentFlags().Lookup("decode_escapes"))
+
+	rootCmd.PersistentFlags().String("level", "info", "Level of the output lines: 'trace', 'debug', 'info', 'warn', 'error', 'fatal' or an offset like 'error+2'")
+	v.BindPFlag("level", rootCmd.PersistentFlags().Lookup("level"))
+
+	rootCmd.PersistentFlags().String("level_distribution", "", "Pick the level of each line at random by weight, ie 'info=80,warn=15,error=5'. Overrides 'level'")
+	v.BindPFlag("level_distribution", rootCmd.PersistentFlags().Lookup("level_distribution"))
+
+	var stackTraceEnumDefault = stackTraceEnumNone // Default value
+	rootCmd.PersistentFlags().Var(&stackTraceEnumDefault, "stack_trace", stackTraceEnumValuesInfoMsg)
+	v.BindPFlag("stack_trace", rootCmd.PersistentFlags().Lookup("stack_trace"))
+
+	rootCmd.PersistentFlags().Float64("stack_trace_ratio", 1, "Share of lines that get a stack trace, from 0 to 1")
+	v.BindPFlag("stack_trace_ratio", rootCmd.PersistentFlags().Lookup("stack_trace_ratio"))
+
+	rootCmd.PersistentFlags().String("stack_trace_mode", stackTraceModeField, "'field' adds the stack trace as a field of the line, 'block' replaces the line with it")
+	v.BindPFlag("stack_trace_mode", rootCmd.PersistentFlags().Lookup("stack_trace_mode"))
+
+	rootCmd.PersistentFlags().StringArray("messages", []string{}, "Repeatable message to pick from for each line instead of the stream's text")
+	v.BindPFlag("messages", rootCmd.PersistentFlags().Lookup("messages"))
+
+	rootCmd.PersistentFlags().String("messages_file", "", "File with one message per line to pick from for each line")
+	v.BindPFlag("messages_file", rootCmd.PersistentFlags().Lookup("messages_file"))
+
+	var messageSelectionEnumDefault = messageSelectionEnumRoundRobin // Default value
+	rootCmd.PersistentFlags().Var(&messageSelectionEnumDefault, "message_selection", messageSelectionEnumValuesInfoMsg)
+	v.BindPFlag("message_selection", rootCmd.PersistentFlags().Lookup("message_selection"))
+
+	rootCmd.PersistentFlags().Bool("file_loop", false, "Start over from the top of the stdout_file, stderr_file or socket_send_file at its end")
+	v.BindPFlag("file_loop", rootCmd.PersistentFlags().Lookup("file_loop"))
+
+	rootCmd.PersistentFlags().Bool("file_follow", false, "Wait for lines to be appended at the end of the file, like 'tail -f'")
+	v.BindPFlag("file_follow", rootCmd.PersistentFlags().Lookup("file_follow"))
+
+	rootCmd.PersistentFlags().Bool("file_pacing", false, "Space the lines of the file out as far as their timestamps are instead of every repeat_interval")
+	v.BindPFlag("file_pacing", rootCmd.PersistentFlags().Lookup("file_pacing"))
+
+	rootCmd.PersistentFlags().String("data_set", "", "CSV or JSON lines file whose rows give each line the values of the 'data_set' interpolator and .Row in templates")
+	v.BindPFlag("data_set", rootCmd.PersistentFlags().Lookup("data_set"))
+
+	rootCmd.PersistentFlags().IntP("sigterm_timeout", "x", 0, "If a sigterm is caught while running wait for X seconds because exiting")
+	v.BindPFlag("sigterm_timeout", rootCmd.PersistentFlags().Lookup("sigterm_timeout"))
+
+	rootCmd.PersistentFlags().StringArray("on_signal", []string{}, "Repeatable 'SIGNAL=action[:arg]' to set what a caught signal does. Allowed actions: '"+strings.Join(signalActions, ", ")+"'")
+	v.BindPFlag("on_signal", rootCmd.PersistentFlags().Lookup("on_signal"))
+
+	rootCmd.PersistentFlags().StringArray("drain_message", []string{}, "Repeatable message written to drain_stream when a shutdown starts")
+	v.BindPFlag("drain_message", rootCmd.PersistentFlags().Lookup("drain_message"))
+
+	rootCmd.PersistentFlags().String("drain_stream", "stdout", "Stream the drain messages are written to: stdout, stderr or socket")
+	v.BindPFlag("drain_stream", rootCmd.PersistentFlags().Lookup("drain_stream"))
+
+	rootCmd.PersistentFlags().Bool("shutdown_stop_output", false, "Stop starting new lines when a shutdown starts and wait up to sigterm_timeout for the blocked writes. Lines written in parts are left unfinished")
+	v.BindPFlag("shutdown_stop_output", rootCmd.PersistentFlags().Lookup("shutdown_stop_output"))
+
+	rootCmd.PersistentFlags().String("shutdown_hang", "0", "Keep running this long past the sigterm_timeout grace period before exiting")
+	v.BindPFlag("shutdown_hang", rootCmd.PersistentFlags().Lookup("shutdown_hang"))
+
+	rootCmd.PersistentFlags().Int("shutdown_exitcode", 0, "Exit with this exit code when a shutdown is done")
+	v.BindPFlag("shutdown_exitcode", rootCmd.PersistentFlags().Lookup("shutdown_exitcode"))
+
+	rootCmd.PersistentFlags().String("second_signal", secondSignalKill, "What a signal caught during a shutdown does: 'kill' lets it kill et, 'force' exits right away with 128+n, 'ignore' ignores it")
+	v.BindPFlag("second_signal", rootCmd.PersistentFlags().Lookup("second_signal"))
+
+	rootCmd.PersistentFlags().String("crash", "", "Die instead of exiting: a signal to kill et with (ie 'SIGKILL', 'SIGSEGV', 'SIGABRT'), '"+crashPanic+"' or '"+crashStackOverflow+"'")
+	v.BindPFlag("crash", rootCmd.PersistentFlags().Lookup("crash"))
+
+	rootCmd.PersistentFlags().String("crash_after", "0", "Crash this long after starting. '0' means when the output is done unless crash_after_lines is set")
+	v.BindPFlag("crash_after", rootCmd.PersistentFlags().Lookup("crash_after"))
+
+	rootCmd.PersistentFlags().Int("crash_after_lines", 0, "Crash once the streams have written this many lines")
+	v.BindPFlag("crash_after_lines", rootCmd.PersistentFlags().Lookup("crash_after_lines"))
+
+	// Must come after the flags it copies
+	addStreamFlags(rootCmd, v)
 
 	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.exectester.yaml)")
 
+	rootCmd.AddCommand(recordCmd(v), replayCmd(v, fallbackLogger), verifyCmd(v), grandchildCmd(v))
+
 	return rootCmd
 }
 
-// Execute adds all child commands to the root command and sets flags appropriately.
-// This is called by main.main(). It only needs to happen once to the rootCmd.
+// Execute runs the command. The config file and env vars are read by the
+// root command before it or a subcommand runs, see initConfig().
+// This is called by main.main().
 func Execute(cmd *cobra.Command) error {
-	cobra.OnInitialize(initConfig)
 	return cmd.Execute()
 }
 
 // initConfig reads in config file and ENV variables if set.
-func initConfig() {
+func initConfig(v *viper.Viper, cfgFile string) {
 	if cfgFile != "" {
 		// Use config file from the flag.
-		viper.SetConfigFile(cfgFile)
+		v.SetConfigFile(cfgFile)
 	} else {
 		// Find home directory.
 		home, err := os.UserHomeDir()
 		cobra.CheckErr(err)
 
 		// Search config in home directory with name ".exectester" (without extension).
-		viper.AddConfigPath(home)
-		viper.SetConfigType("yaml")
-		viper.SetConfigName(".exectester")
+		v.AddConfigPath(home)
+		v.SetConfigType("yaml")
+		v.SetConfigName(".exectester")
 	}
 
 	// This is useless without a config file that hard codes ALL your fields: https://github.com/spf13/viper/issues/584
-	viper.AutomaticEnv()
+	v.AutomaticEnv()
 
 	// If a config file is found, read it in.
-	if err := viper.ReadInConfig(); err == nil {
-		configs.FallbackLogger.Error("Using config file:" + viper.ConfigFileUsed())
+	if err := v.ReadInConfig(); err == nil {
+		configs.FallbackLogger.Error("Using config file:" + v.ConfigFileUsed())
 	}
 }
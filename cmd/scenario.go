@@ -0,0 +1,258 @@
+/*
+Copyright © 2023 Ben Orgil
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+/*
+A scenario is an ordered timeline of steps loaded from the "scenario" key of
+the config file. It lets a single `et` process go through phases instead of
+just printing the same thing N times. For example:
+
+	scenario:
+	  - action: sleep
+	    duration: 2s
+	  - action: emit
+	    stream: stdout
+	    text: "ready"
+	  - action: emit
+	    stream: stderr
+	    text: "error __I__"
+	    interval: 100ms
+	    duration: 5s
+	  - action: wait_signal
+	    signal: SIGUSR1
+	  - action: exit
+	    code: 137
+
+When a scenario is set it replaces the regular stdout/stderr/socket output.
+
+A wait_signal step takes its signal ahead of the signal's on_signal action, so
+waiting for SIGTERM doesn't also start a shutdown. The action is back once the
+step is over.
+*/
+type scenarioStep struct {
+	Action string `mapstructure:"action"`
+	// emit: the stream to write to and the text (interpolated like --stdout)
+	Stream string `mapstructure:"stream"`
+	Text   string `mapstructure:"text"`
+	// emit: write "repeat" times or for "duration", waiting "interval" between writes
+	Repeat   int           `mapstructure:"repeat"`
+	Interval time.Duration `mapstructure:"interval"`
+	// sleep: how long to sleep. wait_signal: give up waiting after this long
+	Duration time.Duration `mapstructure:"duration"`
+	// wait_signal: the signal to wait for
+	Signal string `mapstructure:"signal"`
+	// exit_code and exit: the exit code to use
+	Code *int `mapstructure:"code"`
+}
+
+// Supported scenario step actions
+const (
+	scenarioActionEmit       = "emit"
+	scenarioActionSleep      = "sleep"
+	scenarioActionWaitSignal = "wait_signal"
+	scenarioActionExitCode   = "exit_code"
+	scenarioActionExit       = "exit"
+)
+
+var (
+	scenarioActions = []string{scenarioActionEmit, scenarioActionSleep,
+		scenarioActionWaitSignal, scenarioActionExitCode, scenarioActionExit}
+	scenarioStreams = []string{"stdout", "stderr", "socket"}
+)
+
+// Check every step of a scenario before anything runs, so a typo in step 5
+// doesn't show up after the first 4 steps already ran
+func validateScenario(steps []scenarioStep, socket string) error {
+	for i, s := range steps {
+		switch {
+		case !slices.Contains(scenarioActions, s.Action):
+			return &paramSetValidationError{fmt.Sprintf(
+				"scenario step %v: action '%v' must be one of: %v", i, s.Action, scenarioActions)}
+		case s.Action == scenarioActionEmit && !slices.Contains(scenarioStreams, s.Stream):
+			return &paramSetValidationError{fmt.Sprintf(
+				"scenario step %v: stream '%v' must be one of: %v", i, s.Stream, scenarioStreams)}
+		case s.Action == scenarioActionEmit && s.Stream == "socket" && socket == "":
+			return &paramSetValidationError{fmt.Sprintf(
+				"scenario step %v: emitting to socket requires socket to be set", i)}
+		case s.Action == scenarioActionEmit && s.Duration > 0 && s.Interval <= 0:
+			return &paramSetValidationError{fmt.Sprintf(
+				"scenario step %v: emit with a duration also needs an interval", i)}
+		case s.Action == scenarioActionExitCode && s.Code == nil:
+			return &paramSetValidationError{fmt.Sprintf(
+				"scenario step %v: exit_code requires code", i)}
+		case s.Action == scenarioActionWaitSignal:
+			if _, err := lookupSignal(s.Signal); err != nil {
+				return &paramSetValidationError{fmt.Sprintf("scenario step %v: %v", i, err.Error())}
+			}
+		}
+	}
+	return nil
+}
+
+// Emit a step's text until its repeat count or duration is used up. The
+// sequence of a stream carries on across steps
+func runScenarioEmit(ctx context.Context, cmd *cobra.Command, args viperArgs, step scenarioStep, sequences map[string]*sequencer) {
+	args = args.forStream(step.Stream)
+	args.outputFormatter.Partial = newPartialWriter(args)
+	logger := args.outputFormatter
+
+	repeat := step.Repeat
+	if repeat == 0 && step.Duration == 0 {
+		repeat = 1
+	}
+
+	if step.Duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, step.Duration)
+		defer cancel()
+	}
+	sched := newScheduler(step.Interval)
+	levels := newLevelPicker(args, step.Stream)
+	traces := newStackTracer(args, step.Stream)
+	sequence, ok := sequences[step.Stream]
+	if !ok {
+		sequence = newSequencer(args, step.Stream)
+		sequences[step.Stream] = sequence
+	}
+
+	ictx := newInterpolateContext(step.Stream, args.seed, args.templates)
+	for counter := 0; repeat == 0 || counter < repeat; counter++ {
+		if counter > 0 && !sched.wait(ctx) {
+			break
+		}
+
+		lctx := ictx.next(counter)
+		lctx.Row = args.row(counter)
+		interpolated, err := interpolateStream(args, lctx, step.Text)
+		if err != nil {
+			logger.Logger.Error(err.Error())
+		}
+		fields, err := interpolateFields(args, lctx)
+		if err != nil {
+			logger.Logger.Error(err.Error())
+		}
+		interpolated, fields = traces.apply(interpolated, fields)
+		interpolated, fields = sequence.apply(interpolated, fields)
+		emit(ctx, cmd, args, step.Stream, interpolated, levels.pick(), fields...)
+		args.crash.line()
+	}
+}
+
+// The signals wait_signal steps are waiting for. et's signal loop hands them
+// over instead of running their action
+type signalWaiters struct {
+	mu      sync.Mutex
+	waiting map[os.Signal]chan os.Signal
+}
+
+// Start waiting for sig. A signal with an action comes through the signal
+// loop, any other is caught here. Call stop once done waiting
+func (w *signalWaiters) wait(sig os.Signal, actions map[os.Signal]signalAction) (c <-chan os.Signal, stop func()) {
+	ch := make(chan os.Signal, 1)
+	if _, ok := actions[sig]; !ok {
+		signal.Notify(ch, sig)
+		return ch, func() { signal.Stop(ch) }
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.waiting == nil {
+		w.waiting = map[os.Signal]chan os.Signal{}
+	}
+	w.waiting[sig] = ch
+	return ch, func() {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		delete(w.waiting, sig)
+	}
+}
+
+// Hand sig to the step waiting for it. False if no step is
+func (w *signalWaiters) deliver(sig os.Signal) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	ch, ok := w.waiting[sig]
+	if ok {
+		delete(w.waiting, sig)
+		ch <- sig
+	}
+	return ok
+}
+
+// Run the scenario steps in order. Returns the exit code and whether one was
+// set by an exit_code or exit step
+func runScenario(ctx context.Context, cmd *cobra.Command, args viperArgs, waiters *signalWaiters) (int, bool) {
+	logger := args.outputFormatter
+	exitcode := 0
+	exitcodeSet := false
+	sequences := map[string]*sequencer{}
+
+	for i, step := range args.scenario {
+		// Cancelled by a restart
+		if ctx.Err() != nil {
+			return exitcode, false
+		}
+		switch step.Action {
+		case scenarioActionEmit:
+			runScenarioEmit(ctx, cmd, args, step, sequences)
+		case scenarioActionSleep:
+			select {
+			case <-time.After(step.Duration):
+			case <-ctx.Done():
+			}
+		case scenarioActionWaitSignal:
+			// Already validated
+			sig, _ := lookupSignal(step.Signal)
+			c, stop := waiters.wait(sig, args.signalActions)
+
+			var timeout <-chan time.Time
+			if step.Duration > 0 {
+				timeout = time.After(step.Duration)
+			}
+			select {
+			case <-c:
+				logger.Logger.Info(fmt.Sprintf("Scenario step %v received '%v'", i, sig))
+			case <-timeout:
+				logger.Logger.Info(fmt.Sprintf("Scenario step %v timed out waiting for '%v'", i, sig))
+			case <-ctx.Done():
+			}
+			stop()
+		case scenarioActionExitCode:
+			exitcode = *step.Code
+			exitcodeSet = true
+		case scenarioActionExit:
+			if step.Code != nil {
+				exitcode = *step.Code
+			}
+			return exitcode, true
+		}
+	}
+
+	return exitcode, exitcodeSet
+}
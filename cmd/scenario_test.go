@@ -0,0 +1,71 @@
+/*
+Copyright © 2023 Ben Orgil
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"time"
+)
+
+func (ts *ExecTestSuite) TestScenario() {
+	config := `
+scenario:
+  - action: emit
+    stream: stdout
+    text: starting
+  - action: sleep
+    duration: 1s
+  - action: emit
+    stream: stderr
+    text: err__I__
+    repeat: 3
+    interval: 100ms
+  - action: emit
+    stream: stdout
+    text: done
+`
+	now := time.Now()
+	cmd, err := ts.ExecuteCmdWithConfig(config, []string{})
+	ts.NoError(err)
+	ts.Equal([]string{"starting", "done"}, cmd.StdOut)
+	ts.Equal([]string{"err0", "err1", "err2"}, cmd.StdErr)
+	ts.GreaterOrEqual(time.Since(now), 1200*time.Millisecond)
+}
+
+func (ts *ExecTestSuite) TestScenarioEmitDuration() {
+	config := `
+scenario:
+  - action: emit
+    stream: stdout
+    text: o
+    interval: 100ms
+    duration: 1s
+`
+	cmd, err := ts.ExecuteCmdWithConfig(config, []string{})
+	ts.NoError(err)
+	ts.InDelta(10, cmd.StdOutCount, 2)
+}
+
+func (ts *ExecTestSuite) TestScenarioValidation() {
+	_, err := ts.ExecuteCmdWithConfig("scenario: [{action: explode}]", []string{})
+	ts.ErrorAs(err, new(*paramSetValidationError))
+
+	_, err = ts.ExecuteCmdWithConfig("scenario: [{action: emit, stream: stdin}]", []string{})
+	ts.ErrorAs(err, new(*paramSetValidationError))
+
+	_, err = ts.ExecuteCmdWithConfig("scenario: [{action: wait_signal, signal: SIGNOPE}]", []string{})
+	ts.ErrorAs(err, new(*paramSetValidationError))
+}
@@ -0,0 +1,64 @@
+//go:build unix
+
+/*
+Copyright © 2023 Ben Orgil
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// The step gets the SIGTERM it waits for, the next one starts the shutdown
+// instead of being a second signal that kills et
+func (ts *ExecTestSuite) TestScenarioWaitSignalBeforeAction() {
+	config := `
+scenario:
+  - action: emit
+    stream: stdout
+    text: ready
+  - action: wait_signal
+    signal: SIGTERM
+  - action: emit
+    stream: stdout
+    text: waited
+  - action: sleep
+    duration: 200ms
+  - action: emit
+    stream: stdout
+    text: slept
+  - action: sleep
+    duration: 30s
+`
+	f := filepath.Join(ts.T().TempDir(), "exectester.yaml")
+	ts.Require().NoError(os.WriteFile(f, []byte(config), 0644))
+
+	p := ts.startEt(10*time.Second, "--config="+f, "--sigterm_timeout=1")
+	ts.Require().True(p.waitFor(`"msg":"ready"`))
+	ts.Require().NoError(p.cmd.Process.Signal(syscall.SIGTERM))
+	ts.Require().True(p.waitFor(`"msg":"slept"`))
+	ts.Require().NoError(p.cmd.Process.Signal(syscall.SIGTERM))
+	ts.Require().True(p.wait(), p.stdout.String())
+
+	out := p.stdout.String()
+	ts.Contains(out, "Scenario step 1 received", out)
+	ts.Equal(1, strings.Count(out, "Caught signal. Starting Sigterm timer"), out)
+	ts.Equal(0, p.cmd.ProcessState.ExitCode(), out)
+}
@@ -0,0 +1,99 @@
+/*
+Copyright © 2023 Ben Orgil
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+/*
+Schedules the writes of an output loop.
+
+Sleeping for the interval after every write means the time spent writing
+adds up and the output drifts. Instead every tick is scheduled from the start
+time (start + n*interval) so a slow write only delays the tick it happened
+on. If a write blocks for longer than an interval the ticks it blocked
+through are skipped and counted as missed instead of bursting to catch up.
+
+The deadline comes from the context passed to wait() so a timeout stops the
+loop exactly on time, even in the middle of waiting for a tick.
+*/
+type scheduler struct {
+	interval time.Duration
+	// Target time of the last tick
+	next time.Time
+	// Ticks that were skipped because a write blocked past them
+	missed int
+}
+
+func newScheduler(interval time.Duration) *scheduler {
+	return &scheduler{
+		interval: interval,
+		next:     time.Now(),
+	}
+}
+
+// Wait for the next tick. Returns false if the context is done first. A
+// tick that would land on or after the context's deadline is never fired.
+func (s *scheduler) wait(ctx context.Context) bool {
+	return s.waitFor(ctx, s.interval)
+}
+
+// Same as wait() but the next tick is gap after the last one instead of
+// the scheduler's interval. Used when the rate shaper varies the gaps.
+func (s *scheduler) waitFor(ctx context.Context, gap time.Duration) bool {
+	s.next = s.next.Add(gap)
+
+	if behind := time.Since(s.next); behind > 0 && gap > 0 {
+		missed := int(behind / gap)
+		s.missed += missed
+		s.next = s.next.Add(time.Duration(missed) * gap)
+	}
+
+	if deadline, ok := ctx.Deadline(); ok && !s.next.Before(deadline) {
+		<-ctx.Done()
+		return false
+	}
+
+	timer := time.NewTimer(time.Until(s.next))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// Parse a duration like "250ms" or "1.5s". Bare numbers are seconds so the
+// values of the old integer flags keep working
+func parseDuration(d string) (time.Duration, error) {
+	if d == "" {
+		return 0, nil
+	}
+	if secs, err := strconv.ParseFloat(d, 64); err == nil {
+		return time.Duration(secs * float64(time.Second)), nil
+	}
+	v, err := time.ParseDuration(d)
+	if err != nil {
+		return 0, fmt.Errorf("'%v' is not a duration like '250ms', '1.5s' or a number of seconds", d)
+	}
+	return v, nil
+}
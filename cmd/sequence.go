@@ -0,0 +1,235 @@
+/*
+Copyright © 2023 Ben Orgil
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"log/slog"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+/*
+With --sequence every line of a stream carries the run ID, the stream, a
+sequence number counting up from 1 and a checksum of the text, so
+`et verify` can tell which lines a log shipper dropped, duplicated,
+reordered or mangled:
+
+	et --stdout='line __I__' --repeat=1000 --repeat_interval=0 --sequence --run_id=ship-test
+
+Structured formats get them as "run_id", "stream", "seq" and "checksum"
+fields. Formats without fields (raw and human_readable) and the socket get
+a suffix:
+
+	line 41 run_id=ship-test stream=stdout seq=42 checksum=3f2a9c1d
+
+The checksum is the CRC-32 of the run ID, stream, sequence number and the
+text as it is written, so only the text has to survive the trip unchanged.
+*/
+type sequencer struct {
+	enabled bool
+	runID   string
+	stream  string
+	// Shared with the stream's sequencers before a restart so the numbers go on
+	seq *int
+	// Formats without fields get the sequence as a suffix of the text
+	suffix bool
+	// The checksum covers the text after escapes are decoded
+	decode bool
+}
+
+func newSequencer(args viperArgs, stream string) *sequencer {
+	format := outputFormatterEnum(args.outputFormat)
+	return &sequencer{
+		enabled: args.sequence,
+		runID:   args.runID,
+		stream:  stream,
+		seq:     args.sequenceCounts[stream],
+		// The socket is always sent the bare text
+		suffix: format == outputFormatterEnumRaw || format == outputFormatterEnumHuman || stream == "socket",
+		decode: args.outputFormatter.DecodeEscapes,
+	}
+}
+
+// Random run ID for when --run_id isn't set
+func newRunID() string {
+	b := make([]byte, 4)
+	rand.Read(b)
+	return fmt.Sprintf("%x", b)
+}
+
+func sequenceChecksum(runID string, stream string, seq int, text string) string {
+	return fmt.Sprintf("%08x", crc32.ChecksumIEEE([]byte(runID+"\x00"+stream+"\x00"+strconv.Itoa(seq)+"\x00"+text)))
+}
+
+// Number the next line of the stream
+func (s *sequencer) apply(text string, fields []any) (string, []any) {
+	if !s.enabled {
+		return text, fields
+	}
+	if s.seq == nil {
+		s.seq = new(int)
+	}
+	*s.seq++
+	seq := *s.seq
+
+	written := text
+	if s.decode {
+		written = decodeEscapeSequences(text)
+	}
+	checksum := sequenceChecksum(s.runID, s.stream, seq, written)
+
+	if s.suffix {
+		return fmt.Sprintf("%v run_id=%v stream=%v seq=%v checksum=%v", text, s.runID, s.stream, seq, checksum), fields
+	}
+	return text, append(fields,
+		slog.String("run_id", s.runID),
+		slog.String("stream", s.stream),
+		slog.Int("seq", seq),
+		slog.String("checksum", checksum))
+}
+
+// A numbered line read back by `et verify`
+type sequencedLine struct {
+	runID  string
+	stream string
+	seq    int
+	// Whether the checksum matches the text
+	intact bool
+}
+
+var sequenceSuffixRegexp = regexp.MustCompile(`^(?s)(.*) run_id=(\S+) stream=(\S+) seq=(\d+) checksum=([0-9a-f]{8})$`)
+
+// Keys the text of a line is looked for under when it isn't given
+var verifyMessageKeys = []string{"msg", "message", "short_message", "Body"}
+
+// Find the sequence fields of a line in any of the output formats. Returns
+// false for lines without them
+func parseSequencedLine(line string, messageKey string) (sequencedLine, bool) {
+	line = strings.TrimRight(line, "\r\n")
+
+	// A raw suffix is checked first since the text before it can be anything,
+	// even JSON or logfmt
+	suffixed, suffixFound := sequencedLine{}, false
+	if m := sequenceSuffixRegexp.FindStringSubmatch(line); m != nil {
+		seq, _ := strconv.Atoi(m[4])
+		suffixed = sequencedLine{runID: m[2], stream: m[3], seq: seq,
+			intact: sequenceChecksum(m[2], m[3], seq, m[1]) == m[5]}
+		if suffixed.intact {
+			return suffixed, true
+		}
+		suffixFound = true
+	}
+
+	for _, parse := range []func(string) (map[string]any, bool){parseJSONLine, parseLogfmtLine} {
+		fields, ok := parse(line)
+		if !ok {
+			continue
+		}
+		if l, ok := sequencedFields(fields, messageKey); ok {
+			return l, true
+		}
+	}
+
+	return suffixed, suffixFound
+}
+
+// Read the sequence fields out of a parsed line. GELF prefixes them with '_'
+// and OTel keeps them in "Attributes"
+func sequencedFields(fields map[string]any, messageKey string) (sequencedLine, bool) {
+	if attrs, ok := fields["Attributes"].(map[string]any); ok {
+		for k, v := range attrs {
+			fields[k] = v
+		}
+	}
+	get := func(k string) (string, bool) {
+		for _, key := range []string{k, "_" + k} {
+			if v, ok := fields[key]; ok {
+				return fmt.Sprint(v), true
+			}
+		}
+		return "", false
+	}
+
+	runID, ok1 := get("run_id")
+	stream, ok2 := get("stream")
+	seqStr, ok3 := get("seq")
+	checksum, ok4 := get("checksum")
+	seq, err := strconv.Atoi(seqStr)
+	if !ok1 || !ok2 || !ok3 || !ok4 || err != nil {
+		return sequencedLine{}, false
+	}
+
+	keys := verifyMessageKeys
+	if messageKey != "" {
+		keys = []string{messageKey}
+	}
+	text := ""
+	for _, k := range keys {
+		if v, ok := fields[k]; ok {
+			text = fmt.Sprint(v)
+			break
+		}
+	}
+
+	return sequencedLine{runID: runID, stream: stream, seq: seq,
+		intact: sequenceChecksum(runID, stream, seq, text) == checksum}, true
+}
+
+func parseJSONLine(line string) (map[string]any, bool) {
+	if !strings.HasPrefix(line, "{") {
+		return nil, false
+	}
+	d := json.NewDecoder(bytes.NewReader([]byte(line)))
+	d.UseNumber()
+	m := map[string]any{}
+	if err := d.Decode(&m); err != nil {
+		return nil, false
+	}
+	return m, true
+}
+
+// Split a logfmt line into its keys and values. Quoted values are unquoted
+func parseLogfmtLine(line string) (map[string]any, bool) {
+	m := map[string]any{}
+	for line = strings.TrimLeft(line, " "); line != ""; line = strings.TrimLeft(line, " ") {
+		key, rest, ok := strings.Cut(line, "=")
+		if !ok || key == "" || strings.Contains(key, " ") {
+			return nil, false
+		}
+		var v string
+		if strings.HasPrefix(rest, `"`) {
+			quoted, err := strconv.QuotedPrefix(rest)
+			if err != nil {
+				return nil, false
+			}
+			v, _ = strconv.Unquote(quoted)
+			rest = rest[len(quoted):]
+		} else {
+			v, rest, _ = strings.Cut(rest, " ")
+			rest = " " + rest
+		}
+		m[key] = v
+		line = rest
+	}
+	return m, len(m) > 0
+}
@@ -0,0 +1,179 @@
+/*
+Copyright © 2023 Ben Orgil
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// What a signal caught during a shutdown does
+const (
+	secondSignalKill   = "kill"
+	secondSignalForce  = "force"
+	secondSignalIgnore = "ignore"
+)
+
+var secondSignalValues = []string{secondSignalKill, secondSignalForce, secondSignalIgnore}
+
+/*
+A 'shutdown' signal action stops et like a service asked to stop by its
+orchestrator:
+
+	et --stdout='working __I__' --repeat_forever --sigterm_timeout=10 \
+		--drain_message='draining connections' --shutdown_stop_output \
+		--shutdown_hang=30s --shutdown_exitcode=143 --second_signal=force
+
+It goes through these phases:
+
+ 1. drain: every --drain_message is written to the drain_stream
+ 2. stop: with --shutdown_stop_output no new lines are started and et waits
+    for the writes blocked on the pipes or the socket to finish. A line
+    written in parts (see partialWriter) is left unfinished. Otherwise the
+    output goes on
+ 3. grace: wait sigterm_timeout. With --shutdown_stop_output the wait ends
+    as soon as the output has stopped
+ 4. hang: with --shutdown_hang keep running past the grace period, so
+    whatever stops et has to escalate
+ 5. exit: once the lines being written are finished, with --shutdown_exitcode
+    if it's set, else as usual
+
+A signal caught during the shutdown kills et like it would without et
+catching it ('kill'), makes et exit right away with 128+n ('force') or is
+ignored ('ignore'), unless a scenario's wait_signal step is waiting for it.
+*/
+type shutdownSequence struct {
+	cmd     *cobra.Command
+	v       *viper.Viper
+	args    viperArgs
+	sigs    chan os.Signal
+	waiters *signalWaiters
+}
+
+// Wait for d or until done is closed. Handles the signals caught meanwhile.
+// Returns an *ExitError if one of them forces et to exit
+func (s *shutdownSequence) wait(d time.Duration, done <-chan struct{}) error {
+	logger := s.args.outputFormatter
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+			return nil
+		case <-done:
+			return nil
+		case sig := <-s.sigs:
+			if s.waiters.deliver(sig) {
+				continue
+			}
+			if s.args.secondSignal == secondSignalForce {
+				logger.Logger.Warn(fmt.Sprintf("Caught signal '%v' while shutting down. Exiting right away", signalName(sig)))
+				return &ExitError{Code: killedExitCode(sig), Cause: ExitCauseSignal, Signal: sig}
+			}
+			logger.Logger.Info(fmt.Sprintf("Caught signal '%v' while shutting down. Ignoring it", signalName(sig)))
+		}
+	}
+}
+
+// The drain messages get buffers of their own since the output may still be
+// using the stream's
+func (s *shutdownSequence) drainArgs() viperArgs {
+	args := s.args.forStream(s.args.drainStream)
+	f := args.outputFormatter.getLogger(s.v, args.outputFormat)
+	f.LineTerminator, f.DecodeEscapes = args.outputFormatter.LineTerminator, args.outputFormatter.DecodeEscapes
+	args.outputFormatter = f
+	return args
+}
+
+// Run the shutdown phases. stopOutput stops new lines from being started and
+// outputDone is closed once every output goroutine is done. Returns an
+// *ExitError for shutdown_exitcode or a forced exit, nil otherwise
+func (s *shutdownSequence) run(stopOutput func(), outputDone <-chan struct{}) error {
+	args := s.args
+	logger := args.outputFormatter
+
+	if args.secondSignal == secondSignalKill {
+		// A second signal gets the default behavior, ie a second ctrl+c kills et
+		signal.Stop(s.sigs)
+	}
+
+	grace := time.Duration(args.sigtermTimeout) * time.Second
+	if args.shutdownStopOutput {
+		logger.Logger.Info(fmt.Sprintf("Caught signal. Stopping the output and waiting up to '%v' seconds "+
+			"for the lines being written to finish", args.sigtermTimeout))
+	} else {
+		logger.Logger.Info(
+			fmt.Sprintf(
+				"Caught signal. Starting Sigterm timer to wait for "+
+					"'%v' seconds to shutdown. Output to console will "+
+					"continue while this timer is in effect",
+				args.sigtermTimeout))
+	}
+
+	drainArgs := s.drainArgs()
+	for _, m := range args.drainMessages {
+		emit(context.Background(), s.cmd, drainArgs, args.drainStream, m, drainArgs.level)
+	}
+	if args.shutdownStopOutput {
+		stopOutput()
+	}
+	// Without shutdown_stop_output the grace period always lasts the whole
+	// sigterm_timeout, like it did before there was a shutdown sequence
+	var stopped <-chan struct{}
+	if args.shutdownStopOutput {
+		stopped = outputDone
+	}
+	start := time.Now()
+	if err := s.wait(grace, stopped); err != nil {
+		return s.forced(stopOutput, outputDone, err)
+	}
+	select {
+	case <-stopped:
+		logger.Logger.Info(fmt.Sprintf("Output finished after '%v'", time.Since(start).Round(time.Millisecond)))
+	default:
+		logger.Logger.Info(fmt.Sprintf("Grace period of '%v' is over", grace))
+	}
+
+	if args.shutdownHang > 0 {
+		logger.Logger.Warn(fmt.Sprintf("Hanging for '%v' before exiting", args.shutdownHang))
+		if err := s.wait(args.shutdownHang, nil); err != nil {
+			return s.forced(stopOutput, outputDone, err)
+		}
+	}
+
+	// Finish the lines being written, the output mustn't outlive et
+	stopOutput()
+	<-outputDone
+
+	if args.shutdownExitcodeSet {
+		logger.Logger.Info(fmt.Sprintf("Shutdown done. Exiting with '%v'", args.shutdownExitcode))
+	}
+	return newExitError(args.shutdownExitcode, ExitCauseShutdown)
+}
+
+// Exit right away, once the output has stopped
+func (s *shutdownSequence) forced(stopOutput func(), outputDone <-chan struct{}, err error) error {
+	stopOutput()
+	<-outputDone
+	return err
+}
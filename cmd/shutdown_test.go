@@ -0,0 +1,101 @@
+//go:build unix
+
+/*
+Copyright © 2023 Ben Orgil
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"strings"
+	"syscall"
+	"time"
+)
+
+func (ts *ExecTestSuite) TestShutdownDrain() {
+	p := ts.startEt(10*time.Second, "--stdout=line", "--repeat_forever", "--repeat_interval=100ms",
+		"--sigterm_timeout=5", "--drain_message=draining", "--drain_message=bye", "--drain_stream=stderr",
+		"--shutdown_stop_output", "--output_format=raw")
+	ts.Require().True(p.waitFor("line"))
+	now := time.Now()
+	ts.Require().NoError(p.cmd.Process.Signal(syscall.SIGTERM))
+	ts.Require().True(p.wait(), "timed out")
+
+	// Stopping the output ends the shutdown well before the grace period
+	ts.Less(time.Since(now), 2*time.Second)
+	ts.Equal(0, p.cmd.ProcessState.ExitCode())
+	ts.Equal("draining\nbye\n", p.stderr.String())
+	ts.True(strings.HasPrefix(p.stdout.String(), "line\n"))
+}
+
+func (ts *ExecTestSuite) TestShutdownGrace() {
+	// The output is done long before the grace period is
+	p := ts.startEt(10*time.Second, "--stdout=line", "--repeat=3", "--repeat_interval=100ms", "--sigterm_timeout=2")
+	ts.Require().True(p.waitFor("line"))
+	now := time.Now()
+	ts.Require().NoError(p.cmd.Process.Signal(syscall.SIGTERM))
+	ts.Require().True(p.wait(), "timed out")
+	ts.GreaterOrEqual(time.Since(now), 2*time.Second)
+	ts.Contains(p.stdout.String(), "Grace period of '2s' is over")
+}
+
+func (ts *ExecTestSuite) TestShutdownEscalation() {
+	run := func(secondSignal string) (*etProcess, time.Duration) {
+		p := ts.startEt(10*time.Second, "--stdout=o", "--repeat_forever", "--repeat_interval=50ms",
+			"--sigterm_timeout=0", "--shutdown_hang=1s", "--shutdown_exitcode=42", "--second_signal="+secondSignal)
+		ts.Require().True(p.waitFor(`"msg":"o"`), secondSignal)
+		start := time.Now()
+		ts.Require().NoError(p.cmd.Process.Signal(syscall.SIGTERM))
+		// Only signal again once et is hanging
+		ts.Require().True(p.waitFor("Hanging for"), secondSignal)
+		ts.Require().NoError(p.cmd.Process.Signal(syscall.SIGINT))
+		ts.Require().True(p.wait(), secondSignal)
+		return p, time.Since(start)
+	}
+
+	// Hangs past the grace period then exits with the shutdown exit code
+	p, took := run(secondSignalIgnore)
+	ts.Equal(42, p.cmd.ProcessState.ExitCode())
+	ts.GreaterOrEqual(took, time.Second)
+	ts.Contains(p.stdout.String(), "Hanging for '1s' before exiting")
+	ts.Contains(p.stdout.String(), "Caught signal 'SIGINT' while shutting down. Ignoring it")
+
+	p, took = run(secondSignalForce)
+	ts.Equal(128+int(syscall.SIGINT), p.cmd.ProcessState.ExitCode())
+	ts.Less(took, time.Second)
+
+	// The second signal isn't caught so it kills et
+	p, took = run(secondSignalKill)
+	ts.Equal(-1, p.cmd.ProcessState.ExitCode())
+	ts.Equal(syscall.SIGINT, p.cmd.ProcessState.Sys().(syscall.WaitStatus).Signal())
+	ts.Less(took, time.Second)
+}
+
+func (ts *ExecTestSuite) TestShutdownValidation() {
+	for _, tc := range []struct {
+		name string
+		args []string
+	}{
+		{"unknown second_signal", []string{"--stdout=o", "--second_signal=twice"}},
+		{"unknown drain_stream", []string{"--stdout=o", "--drain_stream=stdin"}},
+		{"drain to socket without a socket", []string{"--stdout=o", "--drain_stream=socket", "--drain_message=bye"}},
+		{"negative shutdown_hang", []string{"--stdout=o", "--shutdown_hang=-1s"}},
+	} {
+		ts.Run(tc.name, func() {
+			_, err := ts.ExecuteCmd(tc.args)
+			ts.ErrorAs(err, new(*paramSetValidationError), tc.args)
+		})
+	}
+}
@@ -0,0 +1,161 @@
+/*
+Copyright © 2023 Ben Orgil
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+const (
+	signalActionIgnore   = "ignore"
+	signalActionLog      = "log"
+	signalActionExit     = "exit"
+	signalActionKilled   = "killed"
+	signalActionRestart  = "restart"
+	signalActionReload   = "reload"
+	signalActionDump     = "dump"
+	signalActionShutdown = "shutdown"
+)
+
+var signalActions = []string{signalActionIgnore, signalActionLog, signalActionExit, signalActionKilled,
+	signalActionRestart, signalActionReload, signalActionDump, signalActionShutdown}
+
+/*
+What et does when it catches a signal, set with --on_signal=SIGNAL=action[:arg]
+or an 'on_signal' map in the config file:
+
+	on_signal:
+	  SIGHUP: reload
+	  SIGUSR1: dump
+	  SIGTERM: exit:143
+
+The actions are:
+
+  - ignore: nothing. An ignored SIGPIPE makes writes to a closed pipe fail
+    instead of killing et
+  - log: log that it was caught
+  - exit[:code]: exit with the code, 0 if it's not given
+  - killed: exit with 128+n like a shell reports a process killed by signal n
+  - restart: stop the output and start it over from the first line
+  - reload: read the config file again and restart the output with it. A
+    seed or run_id picked at random is kept
+  - dump: log the state and write a dump of every goroutine's stack to stderr
+  - shutdown: wait sigterm_timeout while the output goes on, then exit. What
+    SIGINT and SIGTERM do unless they're set
+
+Signals without an action get Go's default behavior.
+*/
+type signalAction struct {
+	action string
+	// Exit code for exit
+	code int
+}
+
+// Parse "SIGNAL=action[:arg]"
+func parseSignalAction(name string, def string) (os.Signal, signalAction, error) {
+	sig, err := lookupSignal(name)
+	if err != nil {
+		return nil, signalAction{}, err
+	}
+
+	action, arg, hasArg := strings.Cut(strings.TrimSpace(def), ":")
+	a := signalAction{action: strings.ToLower(action)}
+	if !slices.Contains(signalActions, a.action) {
+		return nil, a, fmt.Errorf("on_signal %v: '%v' must be one of: %v", name, action, signalActions)
+	}
+	switch {
+	case a.action == signalActionExit && hasArg:
+		if a.code, err = strconv.Atoi(arg); err != nil {
+			return nil, a, fmt.Errorf("on_signal %v: exit code '%v' must be a number", name, arg)
+		}
+	case hasArg:
+		return nil, a, fmt.Errorf("on_signal %v: '%v' doesn't take an argument", name, a.action)
+	}
+	return sig, a, nil
+}
+
+// Read the signal actions from --on_signal and the config file. SIGINT and
+// SIGTERM shut down unless they're set
+func getSignalActions(v *viper.Viper) (map[os.Signal]signalAction, error) {
+	actions := map[os.Signal]signalAction{
+		os.Interrupt:    {action: signalActionShutdown},
+		syscall.SIGTERM: {action: signalActionShutdown},
+	}
+	kvs, err := getKeyValues(v, "on_signal")
+	if err != nil {
+		return nil, err
+	}
+	for _, kv := range kvs {
+		sig, a, err := parseSignalAction(kv[0], kv[1])
+		if err != nil {
+			return nil, err
+		}
+		actions[sig] = a
+	}
+	return actions, nil
+}
+
+// The exit code of a process killed by the signal
+func killedExitCode(sig os.Signal) int {
+	if s, ok := sig.(syscall.Signal); ok {
+		return 128 + int(s)
+	}
+	return 1
+}
+
+// Read the config file again for a reload. A seed or run_id picked at random
+// is taken from the running args
+func reloadViperArgs(cmd *cobra.Command, v *viper.Viper, fallbackLogger *slog.Logger, running viperArgs) (viperArgs, error) {
+	if v.ConfigFileUsed() == "" {
+		return viperArgs{}, fmt.Errorf("there is no config file to reload")
+	}
+	if err := v.ReadInConfig(); err != nil {
+		return viperArgs{}, err
+	}
+	if err := validateParamSets(cmd, v); err != nil {
+		return viperArgs{}, err
+	}
+	args, err := getViperArgs(v, fallbackLogger, &running)
+	if _, ok := err.(*paramSetValidationError); ok {
+		return args, err
+	}
+	logger := args.outputFormatter
+	logger.Logger.Info(fmt.Sprintf("Reloaded config file '%v'", v.ConfigFileUsed()))
+	return args, nil
+}
+
+// Log what et is up to and write every goroutine's stack to stderr, like
+// the thread dump of a JVM
+func dumpState(cmd *cobra.Command, logger OutputFormatter, started time.Time, restarts int, running int) {
+	logger.Logger.Info(fmt.Sprintf("Dumping state: pid '%v', up for '%v', restarted '%v' times, '%v' outputs running",
+		os.Getpid(), time.Since(started).Round(time.Millisecond), restarts, running))
+
+	buf := make([]byte, 1<<20)
+	n := runtime.Stack(buf, true)
+	fmt.Fprintf(cmd.ErrOrStderr(), "%s\n", buf[:n])
+}
@@ -0,0 +1,140 @@
+//go:build unix
+
+/*
+Copyright © 2023 Ben Orgil
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+func (ts *ExecTestSuite) TestParseSignalAction() {
+	sig, a, err := parseSignalAction("usr1", "exit:3")
+	ts.NoError(err)
+	ts.Equal(syscall.SIGUSR1, sig)
+	ts.Equal(signalAction{action: signalActionExit, code: 3}, a)
+
+	_, a, err = parseSignalAction("SIGHUP", "Reload")
+	ts.NoError(err)
+	ts.Equal(signalActionReload, a.action)
+
+	for _, def := range [][2]string{{"SIGHUP", "explode"}, {"SIGWINCH", "log"}, {"SIGTERM", "exit:x"}, {"SIGTERM", "log:3"}} {
+		_, _, err := parseSignalAction(def[0], def[1])
+		ts.Error(err, def)
+	}
+
+	ts.Equal(143, killedExitCode(syscall.SIGTERM))
+}
+
+func (ts *ExecTestSuite) TestSignalActionsConfig() {
+	config := `
+on_signal:
+  SIGHUP: log
+  sigusr2: exit:4
+`
+	f := filepath.Join(ts.T().TempDir(), "exectester.yaml")
+	ts.Require().NoError(os.WriteFile(f, []byte(config), 0644))
+	v := viper.New()
+	v.SetConfigFile(f)
+	ts.Require().NoError(v.ReadInConfig())
+
+	actions, err := getSignalActions(v)
+	ts.Require().NoError(err)
+	ts.Equal(signalAction{action: signalActionLog}, actions[syscall.SIGHUP])
+	ts.Equal(signalAction{action: signalActionExit, code: 4}, actions[syscall.SIGUSR2])
+	// Still shut down by default
+	ts.Equal(signalActionShutdown, actions[syscall.SIGTERM].action)
+
+	_, err = ts.ExecuteCmd([]string{"--stdout=o", "--on_signal=SIGHUP"})
+	ts.ErrorAs(err, new(*paramSetValidationError))
+}
+
+func (ts *ExecTestSuite) TestSignalActionRestart() {
+	p := ts.startEt(10*time.Second, "--stdout=line__I__", "--repeat=5", "--repeat_interval=100ms",
+		"--on_signal=SIGHUP=ignore", "--on_signal=SIGUSR1=restart", "--on_signal=SIGUSR2=dump", "--output_format=raw")
+	ts.Require().True(p.waitFor("line0"))
+	for _, sig := range []syscall.Signal{syscall.SIGHUP, syscall.SIGUSR1, syscall.SIGUSR2} {
+		ts.Require().NoError(p.cmd.Process.Signal(sig))
+		time.Sleep(50 * time.Millisecond)
+	}
+	ts.Require().True(p.wait(), "timed out")
+	ts.Equal(0, p.cmd.ProcessState.ExitCode())
+
+	// Started over from line0 after the restart and ran to the end
+	stdout := p.stdout.String()
+	lines := strings.Split(strings.TrimSpace(stdout), "\n")
+	ts.Greater(len(lines), 5)
+	ts.Equal("line4", lines[len(lines)-1])
+	ts.Equal(2, strings.Count(stdout, "line0\n"))
+	ts.Contains(p.stderr.String(), "goroutine ")
+}
+
+func (ts *ExecTestSuite) TestSignalActionReload() {
+	f := filepath.Join(ts.T().TempDir(), "exectester.yaml")
+	ts.Require().NoError(os.WriteFile(f, []byte("stdout: before\n"), 0644))
+
+	p := ts.startEt(10*time.Second, "--config="+f, "--repeat=5", "--repeat_interval=100ms",
+		"--on_signal=SIGHUP=reload", "--output_format=raw")
+	ts.Require().True(p.waitFor("before"))
+	ts.Require().NoError(os.WriteFile(f, []byte("stdout: after\nrepeat: 2\n"), 0644))
+	ts.Require().NoError(p.cmd.Process.Signal(syscall.SIGHUP))
+	ts.Require().True(p.wait(), "timed out")
+
+	stdout := p.stdout.String()
+	ts.Contains(stdout, "before\n")
+	// The flag still wins over the reloaded config
+	ts.True(strings.HasSuffix(stdout, strings.Repeat("after\n", 5)), stdout)
+}
+
+// The run goes on after a reload so 'et verify' sees one run without gaps
+func (ts *ExecTestSuite) TestSignalActionReloadSequence() {
+	f := filepath.Join(ts.T().TempDir(), "exectester.yaml")
+	ts.Require().NoError(os.WriteFile(f, []byte("stdout: before\n"), 0644))
+
+	p := ts.startEt(10*time.Second, "--config="+f, "--repeat=3", "--repeat_interval=100ms",
+		"--on_signal=SIGHUP=reload", "--output_format=raw", "--sequence")
+	ts.Require().True(p.waitFor("before"))
+	ts.Require().NoError(os.WriteFile(f, []byte("stdout: after\n"), 0644))
+	ts.Require().NoError(p.cmd.Process.Signal(syscall.SIGHUP))
+	ts.Require().True(p.wait(), "timed out")
+
+	out := filepath.Join(ts.T().TempDir(), "out.log")
+	ts.Require().NoError(os.WriteFile(out, []byte(p.stdout.String()), 0644))
+	cmd, err := ts.ExecuteCmd([]string{"verify", out})
+	ts.Require().NoError(err, cmd.RawStdOut)
+	ts.Equal(1, strings.Count(cmd.RawStdOut, "run_id="), cmd.RawStdOut)
+}
+
+func (ts *ExecTestSuite) TestSignalActionExit() {
+	for _, tc := range []struct {
+		action string
+		code   int
+	}{{"exit:7", 7}, {"exit", 0}, {"killed", 128 + int(syscall.SIGTERM)}} {
+		p := ts.startEt(10*time.Second, "--stdout=tick", "--repeat_forever", "--repeat_interval=50ms",
+			"--on_signal=SIGTERM="+tc.action)
+		ts.Require().True(p.waitFor("tick"), tc.action)
+		ts.Require().NoError(p.cmd.Process.Signal(syscall.SIGTERM))
+		ts.Require().True(p.wait(), tc.action)
+		ts.Equal(tc.code, p.cmd.ProcessState.ExitCode(), tc.action)
+	}
+}
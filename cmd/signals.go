@@ -0,0 +1,46 @@
+/*
+Copyright © 2023 Ben Orgil
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Look up a signal by name. Accepts "SIGUSR1", "sigusr1" or just "USR1"
+func lookupSignal(name string) (os.Signal, error) {
+	n := strings.ToUpper(strings.TrimSpace(name))
+	if !strings.HasPrefix(n, "SIG") {
+		n = "SIG" + n
+	}
+	if s, ok := signalNames[n]; ok {
+		return s, nil
+	}
+	return nil, fmt.Errorf("unsupported signal '%v'", name)
+}
+
+// The name of a signal as used in lookupSignal(), ie "SIGTERM". Signals
+// that aren't in the table fall back to Go's description of them
+func signalName(sig os.Signal) string {
+	for name, s := range signalNames {
+		if s == sig {
+			return name
+		}
+	}
+	return sig.String()
+}
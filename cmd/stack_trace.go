@@ -0,0 +1,173 @@
+/*
+Copyright © 2023 Ben Orgil
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+)
+
+/*
+Generates multi-line stack traces to test how log aggregators deal with
+multi-line events. The output text becomes the error message of the trace.
+
+	et --stderr='order __I__ failed' --repeat=10 --stack_trace=java \
+		--stack_trace_ratio=0.3 --stack_trace_mode=block --output_format=raw
+
+With stack_trace_mode=field the trace goes in a "stack_trace" field of the
+line. With block it replaces the line, which is only multi-line with
+output_format=raw or human_readable, the other formats escape the new lines.
+stack_trace_ratio is the share of lines that get a trace, the rest are
+written as usual.
+
+The frames are picked at random from the pools below, reproducibly with --seed.
+*/
+const (
+	stackTraceModeField = "field"
+	stackTraceModeBlock = "block"
+)
+
+var stackTraceModes = []string{stackTraceModeField, stackTraceModeBlock}
+
+// A frame of an application's own code. Framework frames are fixed per language
+type stackFrame struct {
+	class string
+	fn    string
+	file  string
+	// The line of code, shown by Python tracebacks
+	code string
+}
+
+var stackFramePool = []stackFrame{
+	{"OrderService", "process", "orders", "result = self.repository.save(order)"},
+	{"OrderController", "create", "orders_controller", "order = service.process(request.body)"},
+	{"PaymentClient", "charge", "payments", "response = self.session.post(url, json=payload)"},
+	{"InventoryService", "reserve", "inventory", "stock = self.cache.get(sku)"},
+	{"UserRepository", "findById", "users", "row = cursor.fetchone()"},
+	{"AuthMiddleware", "verify", "auth", "claims = jwt.decode(token, key)"},
+	{"ReportJob", "run", "reports", "rows = self.query(start, end)"},
+	{"CacheClient", "get", "cache", "value = self.conn.execute(cmd)"},
+}
+
+// Exceptions a Java trace is caused by
+var javaCauses = []string{
+	"java.sql.SQLTimeoutException: Connection is not available, request timed out after 30000ms",
+	"java.net.SocketTimeoutException: Read timed out",
+	"java.lang.NullPointerException: Cannot invoke \"String.length()\" because \"name\" is null",
+	"java.io.IOException: Broken pipe",
+}
+
+// Picks which lines get a stack trace and generates them
+type stackTracer struct {
+	kind  stackTraceEnum
+	mode  string
+	ratio float64
+	rand  *rand.Rand
+}
+
+func newStackTracer(args viperArgs, stream string) *stackTracer {
+	return &stackTracer{
+		kind:  stackTraceEnum(args.stackTrace),
+		mode:  args.stackTraceMode,
+		ratio: args.stackTraceRatio,
+		rand:  newStreamRand(args.seed, stream+"_stack_trace"),
+	}
+}
+
+// Add a stack trace to a line if it was picked for one. Returns the new
+// text and fields of the line
+func (s *stackTracer) apply(text string, fields []any) (string, []any) {
+	if s.kind == stackTraceEnumNone || s.kind == "" || s.rand.Float64() >= s.ratio {
+		return text, fields
+	}
+	trace := s.generate(text)
+	if s.mode == stackTraceModeBlock {
+		return trace, fields
+	}
+	return text, append(fields, "stack_trace", trace)
+}
+
+// Some frames of application code, outermost last
+func (s *stackTracer) frames() []stackFrame {
+	n := 2 + s.rand.Intn(3)
+	frames := make([]stackFrame, n)
+	for i := range frames {
+		frames[i] = stackFramePool[s.rand.Intn(len(stackFramePool))]
+	}
+	return frames
+}
+
+func (s *stackTracer) line() int {
+	return 10 + s.rand.Intn(290)
+}
+
+func (s *stackTracer) generate(msg string) string {
+	var b strings.Builder
+	switch s.kind {
+	case stackTraceEnumGo:
+		fmt.Fprintf(&b, "panic: %v\n\ngoroutine %v [running]:\n", msg, 1+s.rand.Intn(200))
+		for _, f := range s.frames() {
+			fmt.Fprintf(&b, "main.(*%v).%v(0xc%09x)\n\t/app/%v.go:%v +0x%x\n",
+				f.class, strings.ToUpper(f.fn[:1])+f.fn[1:], s.rand.Int63n(1<<32), f.file, s.line(), s.rand.Intn(0x400))
+		}
+		b.WriteString("net/http.HandlerFunc.ServeHTTP(...)\n\t/usr/local/go/src/net/http/server.go:2166\n")
+		b.WriteString("net/http.(*conn).serve(0xc000132000, {0x7e3d28, 0xc0000a2150})\n\t/usr/local/go/src/net/http/server.go:2009 +0x5f4\n")
+		b.WriteString("created by net/http.(*Server).Serve in goroutine 1\n\t/usr/local/go/src/net/http/server.go:3086 +0x5cb")
+	case stackTraceEnumJava:
+		fmt.Fprintf(&b, "java.lang.IllegalStateException: %v\n", msg)
+		for _, f := range s.frames() {
+			fmt.Fprintf(&b, "\tat com.example.app.%v.%v(%v.java:%v)\n", f.class, f.fn, f.class, s.line())
+		}
+		b.WriteString("\tat org.springframework.web.servlet.FrameworkServlet.service(FrameworkServlet.java:897)\n")
+		b.WriteString("\tat java.base/java.lang.Thread.run(Thread.java:833)")
+		for causes := 1 + s.rand.Intn(2); causes > 0; causes-- {
+			fmt.Fprintf(&b, "\nCaused by: %v\n", javaCauses[s.rand.Intn(len(javaCauses))])
+			for _, f := range s.frames()[:2] {
+				fmt.Fprintf(&b, "\tat com.example.app.%v.%v(%v.java:%v)\n", f.class, f.fn, f.class, s.line())
+			}
+			fmt.Fprintf(&b, "\t... %v more", 2+s.rand.Intn(20))
+		}
+	case stackTraceEnumPython:
+		b.WriteString("Traceback (most recent call last):\n")
+		for _, f := range s.frames() {
+			fmt.Fprintf(&b, "  File \"/app/%v.py\", line %v, in %v\n    %v\n", f.file, s.line(), pythonName(f.fn), f.code)
+		}
+		fmt.Fprintf(&b, "RuntimeError: %v", msg)
+	case stackTraceEnumNode:
+		fmt.Fprintf(&b, "Error: %v\n", msg)
+		for _, f := range s.frames() {
+			fmt.Fprintf(&b, "    at %v.%v (/app/src/%v.js:%v:%v)\n", f.class, f.fn, f.file, s.line(), 1+s.rand.Intn(40))
+		}
+		b.WriteString("    at Layer.handle [as handle_request] (/app/node_modules/express/lib/router/layer.js:95:5)\n")
+		b.WriteString("    at process.processTicksAndRejections (node:internal/process/task_queues:95:5)")
+	}
+	return b.String()
+}
+
+// findById -> find_by_id
+func pythonName(fn string) string {
+	var b strings.Builder
+	for _, r := range fn {
+		if r >= 'A' && r <= 'Z' {
+			b.WriteByte('_')
+			r += 'a' - 'A'
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -0,0 +1,90 @@
+/*
+Copyright © 2023 Ben Orgil
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+func (ts *ExecTestSuite) TestStackTraceField() {
+	cmd, err := ts.ExecuteCmd([]string{"--stderr=boom", "--stack_trace=python", "--seed=1"})
+	ts.Require().NoError(err)
+	m := map[string]any{}
+	ts.Require().NoError(json.Unmarshal([]byte(cmd.RawStdErr), &m))
+	ts.Equal("boom", m["msg"])
+	trace := m["stack_trace"].(string)
+	ts.True(strings.HasPrefix(trace, "Traceback (most recent call last):\n"))
+	ts.True(strings.HasSuffix(trace, "RuntimeError: boom"))
+
+	// Same seed, same trace
+	cmd, err = ts.ExecuteCmd([]string{"--stderr=boom", "--stack_trace=python", "--seed=1"})
+	ts.Require().NoError(err)
+	m2 := map[string]any{}
+	ts.Require().NoError(json.Unmarshal([]byte(cmd.RawStdErr), &m2))
+	ts.Equal(trace, m2["stack_trace"])
+}
+
+func (ts *ExecTestSuite) TestStackTraceBlock() {
+	for kind, expected := range map[string]string{
+		"go":     "panic: failed __I__\n\ngoroutine ",
+		"java":   "java.lang.IllegalStateException: failed __I__\n\tat com.example.app.",
+		"python": "Traceback (most recent call last):\n  File \"/app/",
+		"node":   "Error: failed __I__\n    at ",
+	} {
+		cmd, err := ts.ExecuteCmd([]string{"--stderr=failed __I__", "--interpolator=string", "--interpolate_val=__I__",
+			"--stack_trace=" + kind, "--stack_trace_mode=block", "--output_format=raw"})
+		ts.Require().NoError(err)
+		ts.True(strings.HasPrefix(cmd.RawStdErr, expected), "%v: %v", kind, cmd.RawStdErr)
+		ts.Greater(strings.Count(cmd.RawStdErr, "\n"), 3, kind)
+		if kind == "java" {
+			ts.Contains(cmd.RawStdErr, "\nCaused by: ")
+		}
+		// go run prints "exit status 2" after the trace, the panicking program doesn't
+		if kind == "go" {
+			ts.True(strings.HasSuffix(cmd.RawStdErr, "server.go:3086 +0x5cb\n"), cmd.RawStdErr)
+		}
+	}
+
+	// human_readable keeps the new lines too
+	cmd, err := ts.ExecuteCmd([]string{"--stdout=oops", "--stack_trace=node", "--stack_trace_mode=block", "--output_format=human_readable"})
+	ts.Require().NoError(err)
+	ts.True(strings.HasPrefix(cmd.RawStdOut, "Error: oops\n    at "))
+}
+
+func (ts *ExecTestSuite) TestStackTraceRatio() {
+	cmd, err := ts.ExecuteCmd([]string{"--stdout=o", "--stderr=e", "--repeat=400", "--repeat_interval=0", "--seed=3",
+		"--stack_trace=java", "--stack_trace_ratio=0.25", "--stdout_stack_trace=none"})
+	ts.Require().NoError(err)
+	traces := 0
+	for _, l := range strings.Split(strings.TrimSuffix(cmd.RawStdErr, "\n"), "\n") {
+		if strings.Contains(l, `"stack_trace":`) {
+			traces++
+		}
+	}
+	ts.InDelta(100, traces, 30)
+	ts.NotContains(cmd.RawStdOut, "stack_trace")
+
+	for _, args := range [][]string{
+		{"--stdout=o", "--stack_trace=java", "--stack_trace_ratio=1.5"},
+		{"--stdout=o", "--stack_trace=java", "--stack_trace_mode=inline"},
+		{"--stdout=o", "--stack_trace=ruby"},
+	} {
+		_, err = ts.ExecuteCmd(args)
+		ts.Error(err, args)
+	}
+}
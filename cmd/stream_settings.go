@@ -0,0 +1,292 @@
+/*
+Copyright © 2023 Ben Orgil
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"log/slog"
+	"regexp"
+	"slices"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+/*
+Every output stream has its own copy of the repeat, timing and interpolation
+settings. By default they are the global values but each one can be
+overridden with a prefixed flag:
+
+	et --stdout=o --stderr=e --repeat_forever --repeat_interval=1 \
+		--stderr_repeat_interval=5 --socket_repeat=3
+
+or a block in the config file:
+
+	streams:
+	  stderr:
+	    repeat_interval: 5
+	  socket:
+	    repeat: 3
+*/
+var (
+	outputStreams  = []string{"stdout", "stderr", "socket"}
+	streamSettings = []string{"repeat", "repeat_interval", "repeat_forever", "timeout",
+		"interpolator", "interpolate_key", "interpolate_val", "interpolate",
+		"rate_mode", "rate", "burst_size", "jitter", "level", "level_distribution",
+		"stack_trace", "stack_trace_ratio", "stack_trace_mode",
+		"messages", "messages_file", "message_selection",
+		"file_loop", "file_follow", "file_pacing", "data_set", "sequence",
+		"write_chunks", "write_delay", "trickle", "no_final_newline"}
+)
+
+// Settings resolved for a single output stream
+type streamArgs struct {
+	repeat int
+	// Whether repeat was set rather than left at its default
+	repeatSet         bool
+	repeatInterval    time.Duration
+	repeatForever     bool
+	timeout           time.Duration
+	interpolateKey    string
+	interpolator      string
+	interpolateVal    string
+	interpolations    []interpolation
+	interpolationsRe  *regexp.Regexp
+	rateMode          string
+	rate              float64
+	burstSize         int
+	jitter            time.Duration
+	level             slog.Level
+	levelDistribution []weightedLevel
+	stackTrace        string
+	stackTraceRatio   float64
+	stackTraceMode    string
+	messages          []message
+	messageSelection  string
+	fileLoop          bool
+	fileFollow        bool
+	filePacing        bool
+	dataSet           []dataSetRow
+	sequence          bool
+	writeChunks       int
+	writeDelay        time.Duration
+	trickle           bool
+	noFinalNewline    bool
+}
+
+// Viper key of a stream's setting
+func streamSettingKey(stream string, setting string) string {
+	return "streams." + stream + "." + setting
+}
+
+// Returns a func giving the viper key to read a setting from for this
+// stream. Falls back to the global setting if the stream doesn't set it
+func streamKey(v *viper.Viper, stream string) func(string) string {
+	return func(setting string) string {
+		if stream != "" && v.IsSet(streamSettingKey(stream, setting)) {
+			return streamSettingKey(stream, setting)
+		}
+		return setting
+	}
+}
+
+// Adds a prefixed copy of every per stream setting's flag, ie --stderr_repeat.
+// Must be called after the global flags are defined since it copies them.
+func addStreamFlags(cmd *cobra.Command, v *viper.Viper) {
+	flags := cmd.PersistentFlags()
+	for _, stream := range outputStreams {
+		for _, setting := range streamSettings {
+			f := flags.Lookup(setting)
+			name := stream + "_" + setting
+			usage := fmt.Sprintf("Overrides '%v' for %v", setting, stream)
+
+			// Defaults are never used since unset flags fall back to the
+			// global setting. Zero values keep them out of the help text.
+			switch f.Value.Type() {
+			case "int":
+				flags.Int(name, 0, usage)
+			case "float64":
+				flags.Float64(name, 0, usage)
+			case "bool":
+				flags.Bool(name, false, usage)
+			case "stringArray":
+				flags.StringArray(name, []string{}, usage)
+			case "interpolatorEnum":
+				e := interpolatorEnum("")
+				flags.Var(&e, name, usage)
+			case "rateModeEnum":
+				e := rateModeEnum("")
+				flags.Var(&e, name, usage)
+			case "stackTraceEnum":
+				e := stackTraceEnum("")
+				flags.Var(&e, name, usage)
+			case "messageSelectionEnum":
+				e := messageSelectionEnum("")
+				flags.Var(&e, name, usage)
+			default:
+				flags.String(name, "", usage)
+			}
+			// There are too many of these for the help text. Documented in RootCmd's Long help instead
+			flags.MarkHidden(name)
+			v.BindPFlag(streamSettingKey(stream, setting), flags.Lookup(name))
+		}
+	}
+}
+
+// Read the repeat, timing and interpolation settings. The key func decides
+// which viper key each setting is read from (see streamKey())
+func getStreamArgs(v *viper.Viper, key func(string) string) (streamArgs, error) {
+	s := streamArgs{
+		repeat:           v.GetInt(key("repeat")),
+		repeatSet:        v.IsSet(key("repeat")),
+		repeatForever:    v.GetBool(key("repeat_forever")),
+		interpolateKey:   v.GetString(key("interpolate_key")),
+		interpolator:     v.GetString(key("interpolator")),
+		interpolateVal:   v.GetString(key("interpolate_val")),
+		rateMode:         v.GetString(key("rate_mode")),
+		rate:             v.GetFloat64(key("rate")),
+		burstSize:        v.GetInt(key("burst_size")),
+		stackTrace:       v.GetString(key("stack_trace")),
+		stackTraceRatio:  v.GetFloat64(key("stack_trace_ratio")),
+		stackTraceMode:   v.GetString(key("stack_trace_mode")),
+		messageSelection: v.GetString(key("message_selection")),
+		fileLoop:         v.GetBool(key("file_loop")),
+		fileFollow:       v.GetBool(key("file_follow")),
+		filePacing:       v.GetBool(key("file_pacing")),
+		sequence:         v.GetBool(key("sequence")),
+		writeChunks:      v.GetInt(key("write_chunks")),
+		trickle:          v.GetBool(key("trickle")),
+		noFinalNewline:   v.GetBool(key("no_final_newline")),
+	}
+
+	var err error
+	if s.repeatInterval, err = parseDuration(v.GetString(key("repeat_interval"))); err != nil {
+		return s, fmt.Errorf("%v: %v", key("repeat_interval"), err.Error())
+	}
+	if s.timeout, err = parseDuration(v.GetString(key("timeout"))); err != nil {
+		return s, fmt.Errorf("%v: %v", key("timeout"), err.Error())
+	}
+	if s.jitter, err = parseDuration(v.GetString(key("jitter"))); err != nil {
+		return s, fmt.Errorf("%v: %v", key("jitter"), err.Error())
+	}
+	if s.writeDelay, err = parseDuration(v.GetString(key("write_delay"))); err != nil {
+		return s, fmt.Errorf("%v: %v", key("write_delay"), err.Error())
+	}
+
+	if s.level, err = parseLevel(v.GetString(key("level"))); err != nil {
+		return s, fmt.Errorf("%v: %v", key("level"), err.Error())
+	}
+	if s.levelDistribution, err = getLevelDistribution(v, key("level_distribution")); err != nil {
+		return s, err
+	}
+
+	interpolations, err := getInterpolations(v, key)
+	if err != nil {
+		return s, err
+	}
+	s.interpolations = interpolations
+	s.interpolationsRe = interpolationsRegexp(interpolations)
+
+	if s.messages, err = getMessages(v, key); err != nil {
+		return s, err
+	}
+
+	if file := v.GetString(key("data_set")); file != "" {
+		if s.dataSet, err = loadDataSet(file); err != nil {
+			return s, fmt.Errorf("%v: %v", key("data_set"), err.Error())
+		}
+	}
+
+	return s, nil
+}
+
+// Returns a copy of args with a stream's settings in place of the global ones
+func (args viperArgs) forStream(stream string) viperArgs {
+	if s, ok := args.streams[stream]; ok {
+		args.streamArgs = s
+	}
+	return args
+}
+
+// Check the global and per stream settings. Flags are already checked by
+// their type but values from the config file are not
+func validateStreamSettings(v *viper.Viper) error {
+	for stream, block := range v.GetStringMap("streams") {
+		if !slices.Contains(outputStreams, stream) {
+			return &paramSetValidationError{fmt.Sprintf(
+				"streams: '%v' must be one of: %v", stream, outputStreams)}
+		}
+		settings, _ := block.(map[string]any)
+		for setting := range settings {
+			if !slices.Contains(streamSettings, setting) {
+				return &paramSetValidationError{fmt.Sprintf(
+					"streams.%v: '%v' must be one of: %v", stream, setting, streamSettings)}
+			}
+		}
+	}
+
+	for _, stream := range append([]string{""}, outputStreams...) {
+		key := streamKey(v, stream)
+		if v.GetInt(key("repeat")) < 0 {
+			return &paramSetValidationError{fmt.Sprintf("'%v' can't be negative", key("repeat"))}
+		}
+		if v.GetInt(key("write_chunks")) < 1 {
+			return &paramSetValidationError{fmt.Sprintf("'%v' must be at least 1", key("write_chunks"))}
+		}
+		for _, setting := range []string{"repeat_interval", "timeout", "jitter", "write_delay"} {
+			d, err := parseDuration(v.GetString(key(setting)))
+			if err != nil {
+				return &paramSetValidationError{fmt.Sprintf("'%v': %v", key(setting), err.Error())}
+			}
+			if d < 0 {
+				return &paramSetValidationError{fmt.Sprintf("'%v' can't be negative", key(setting))}
+			}
+		}
+		if i := v.GetString(key("interpolator")); !slices.Contains(interpolatorEnumValues, i) {
+			return &paramSetValidationError{fmt.Sprintf("'%v' %v", key("interpolator"), interpolatorEnumValuesErrMsg)}
+		}
+
+		switch m := rateModeEnum(v.GetString(key("rate_mode"))); {
+		case !slices.Contains(rateModeEnumValues, string(m)):
+			return &paramSetValidationError{fmt.Sprintf("'%v' %v", key("rate_mode"), rateModeEnumValuesErrMsg)}
+		case (m == rateModeEnumLinesPerSec || m == rateModeEnumBytesPerSec || m == rateModeEnumPoisson) &&
+			v.GetFloat64(key("rate")) <= 0:
+			return &paramSetValidationError{fmt.Sprintf("rate_mode '%v' requires '%v' > 0", m, key("rate"))}
+		case m == rateModeEnumBurst && v.GetInt(key("burst_size")) <= 0:
+			return &paramSetValidationError{fmt.Sprintf("rate_mode '%v' requires '%v' > 0", m, key("burst_size"))}
+		}
+
+		if t := v.GetString(key("stack_trace")); !slices.Contains(stackTraceEnumValues, t) {
+			return &paramSetValidationError{fmt.Sprintf("'%v' %v", key("stack_trace"), stackTraceEnumValuesErrMsg)}
+		}
+		if m := v.GetString(key("stack_trace_mode")); !slices.Contains(stackTraceModes, m) {
+			return &paramSetValidationError{fmt.Sprintf("'%v' must be one of: %v", key("stack_trace_mode"), stackTraceModes)}
+		}
+		if r := v.GetFloat64(key("stack_trace_ratio")); r < 0 || r > 1 {
+			return &paramSetValidationError{fmt.Sprintf("'%v' must be between 0 and 1", key("stack_trace_ratio"))}
+		}
+		if s := v.GetString(key("message_selection")); !slices.Contains(messageSelectionEnumValues, s) {
+			return &paramSetValidationError{fmt.Sprintf("'%v' %v", key("message_selection"), messageSelectionEnumValuesErrMsg)}
+		}
+		if v.GetBool(key("file_loop")) && v.GetBool(key("file_follow")) {
+			return &paramSetValidationError{fmt.Sprintf("'%v' and '%v' can't both be set", key("file_loop"), key("file_follow"))}
+		}
+	}
+
+	return nil
+}
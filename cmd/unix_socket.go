@@ -85,8 +85,9 @@ func getunixSocket(logger slog.Logger, socketName string, timeout int) (*unixSoc
 	return s, err
 }
 
-func (s *unixSocket) sendTounixSocket(data string) error {
-	_, err := s.conn.Write([]byte(data))
+// Send a line. The partial writer may split it up, nil sends it in one go
+func (s *unixSocket) sendTounixSocket(ctx context.Context, data string, p *partialWriter) error {
+	_, err := p.write(ctx, s.conn, data, "\n")
 	return err
 }
 
@@ -0,0 +1,331 @@
+/*
+Copyright © 2023 Ben Orgil
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// Holds all the viper args of the verify subcommand
+type verifyArgs struct {
+	socket      string
+	idleTimeout time.Duration
+	messageKey  string
+	expect      int
+}
+
+// Longest line verify can read
+const verifyMaxLine = 1024 * 1024
+
+// Lines of one stream of one run
+type sequenceKey struct {
+	runID  string
+	stream string
+}
+
+// What was seen of a stream
+type sequenceStats struct {
+	lines      int
+	duplicates int
+	reordered  int
+	corrupt    int
+	last       int
+	seen       map[int]bool
+}
+
+/*
+Checks the sequence numbers of the lines written with --sequence. Each
+stream of each run is checked on its own, so the output of several streams
+and runs can be mixed together like a log shipper would:
+
+  - missing: sequence numbers that never showed up, up to the highest one
+    seen or --expect
+  - duplicates: sequence numbers seen more than once
+  - reordered: lines that showed up after a line with a higher number
+  - corrupt: lines whose checksum doesn't match their text
+
+Lines without a sequence are counted but otherwise ignored.
+*/
+type verifier struct {
+	messageKey  string
+	streams     map[sequenceKey]*sequenceStats
+	order       []sequenceKey
+	unsequenced int
+}
+
+func newVerifier(messageKey string) *verifier {
+	return &verifier{messageKey: messageKey, streams: map[sequenceKey]*sequenceStats{}}
+}
+
+func (v *verifier) add(line string) {
+	l, ok := parseSequencedLine(line, v.messageKey)
+	if !ok {
+		if strings.TrimSpace(line) != "" {
+			v.unsequenced++
+		}
+		return
+	}
+
+	key := sequenceKey{l.runID, l.stream}
+	s, ok := v.streams[key]
+	if !ok {
+		s = &sequenceStats{seen: map[int]bool{}}
+		v.streams[key] = s
+		v.order = append(v.order, key)
+	}
+
+	s.lines++
+	switch {
+	case !l.intact:
+		s.corrupt++
+	case s.seen[l.seq]:
+		s.duplicates++
+	case l.seq < s.last:
+		s.reordered++
+	}
+	s.seen[l.seq] = true
+	s.last = max(s.last, l.seq)
+}
+
+// Read every line of r
+func (v *verifier) read(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, verifyMaxLine)
+	for scanner.Scan() {
+		v.add(scanner.Text())
+	}
+	return scanner.Err()
+}
+
+// Collapse the missing sequence numbers into ranges, ie "3-5, 9"
+func missingRanges(missing []int, limit int) string {
+	var ranges []string
+	for i := 0; i < len(missing); {
+		j := i
+		for j+1 < len(missing) && missing[j+1] == missing[j]+1 {
+			j++
+		}
+		if len(ranges) == limit {
+			ranges = append(ranges, "...")
+			break
+		}
+		if i == j {
+			ranges = append(ranges, fmt.Sprint(missing[i]))
+		} else {
+			ranges = append(ranges, fmt.Sprintf("%v-%v", missing[i], missing[j]))
+		}
+		i = j + 1
+	}
+	return strings.Join(ranges, ", ")
+}
+
+// Write what was found for each stream. Returns false if anything is wrong
+// or no numbered lines were found at all
+func (v *verifier) report(w io.Writer, expect int) bool {
+	ok := len(v.order) > 0
+	for _, key := range v.order {
+		s := v.streams[key]
+		var missing []int
+		for seq := 1; seq <= max(s.last, expect); seq++ {
+			if !s.seen[seq] {
+				missing = append(missing, seq)
+			}
+		}
+
+		fmt.Fprintf(w, "run_id=%v stream=%v lines=%v last_seq=%v missing=%v duplicates=%v reordered=%v corrupt=%v\n",
+			key.runID, key.stream, s.lines, s.last, len(missing), s.duplicates, s.reordered, s.corrupt)
+		if len(missing) > 0 {
+			fmt.Fprintf(w, "  missing seq: %v\n", missingRanges(missing, 10))
+		}
+		if len(missing) > 0 || s.duplicates > 0 || s.reordered > 0 || s.corrupt > 0 {
+			ok = false
+		}
+	}
+
+	if v.unsequenced > 0 {
+		fmt.Fprintf(w, "lines without a sequence: %v\n", v.unsequenced)
+	}
+	if ok {
+		fmt.Fprintln(w, "OK")
+	} else {
+		fmt.Fprintln(w, "FAILED")
+	}
+	return ok
+}
+
+// Listen on a unix socket and read the lines of every connection until no
+// line arrives for the idle timeout or a signal is caught. Connections are
+// read one at a time in the order they were accepted, since --socket sends
+// every line on a new connection
+func (v *verifier) listen(ctx context.Context, socket string, idle time.Duration) error {
+	os.Remove(socket)
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(socket)
+	defer l.Close()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	lines := make(chan string)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			scanner := bufio.NewScanner(conn)
+			scanner.Buffer(nil, verifyMaxLine)
+			for scanner.Scan() {
+				select {
+				case lines <- scanner.Text():
+				case <-ctx.Done():
+					conn.Close()
+					return
+				}
+			}
+			conn.Close()
+		}
+	}()
+
+	timer := time.NewTimer(idle)
+	defer timer.Stop()
+	for {
+		select {
+		case line := <-lines:
+			v.add(line)
+			timer.Reset(idle)
+		case <-timer.C:
+			return nil
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// Read captured output from the files, stdin or a unix socket and report on
+// it. Returns an *ExitError with 1 if lines were lost, duplicated, reordered
+// or corrupted
+func verify(cmd *cobra.Command, v *viper.Viper, files []string) error {
+	bindEnvToFlags(v)
+
+	args := verifyArgs{
+		socket:     v.GetString("verify.socket"),
+		messageKey: v.GetString("verify.message_key"),
+		expect:     v.GetInt("verify.expect"),
+	}
+	var err error
+	if args.idleTimeout, err = parseDuration(v.GetString("verify.idle_timeout")); err != nil {
+		return &paramSetValidationError{fmt.Sprintf("idle_timeout: %v", err.Error())}
+	}
+	switch {
+	case args.socket != "" && len(files) > 0:
+		return &paramSetValidationError{"read from either files or socket, not both"}
+	case args.idleTimeout <= 0:
+		return &paramSetValidationError{"idle_timeout must be > 0"}
+	case args.expect < 0:
+		return &paramSetValidationError{"expect can't be negative"}
+	}
+
+	vr := newVerifier(args.messageKey)
+	switch {
+	case args.socket != "":
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		if err := vr.listen(ctx, args.socket, args.idleTimeout); err != nil {
+			return err
+		}
+	case len(files) == 0 || (len(files) == 1 && files[0] == "-"):
+		if err := vr.read(cmd.InOrStdin()); err != nil {
+			return err
+		}
+	default:
+		for _, file := range files {
+			f, err := os.Open(file)
+			if err != nil {
+				return err
+			}
+			err = vr.read(f)
+			f.Close()
+			if err != nil {
+				return fmt.Errorf("%v: %v", file, err.Error())
+			}
+		}
+	}
+
+	if !vr.report(cmd.OutOrStdout(), args.expect) {
+		return newExitError(1, ExitCauseVerify)
+	}
+	return nil
+}
+
+func verifyCmd(v *viper.Viper) *cobra.Command {
+	verifyCmd := &cobra.Command{
+		Use:   "verify [flags] [file...]",
+		Short: "Check output written with --sequence for lost, duplicated, reordered or corrupted lines",
+		Long: `Reads output written with --sequence and reports, for every stream of every
+run, the sequence numbers that are missing, duplicated or out of order and
+the lines whose checksum doesn't match their text. Reads the given files,
+stdin if there are none or '-', or listens on a unix socket. Any output
+format can be read, mixed together in any order. Exits with 1 if anything
+is wrong or no numbered lines were found.
+
+Example Usage
+-------------
+Check what a log shipper delivered to a file:
+$ et --stdout='line __I__' --repeat=10000 --repeat_interval=1ms --sequence --run_id=test1
+$ et verify --expect=10000 delivered.log
+
+Check lines sent straight to the verifier:
+$ et --stdout='line __I__' --repeat=100 --repeat_interval=0 --sequence | et verify
+
+Listen on a unix socket until nothing arrives for 5 seconds:
+$ et verify --socket=/tmp/verify.sock --idle_timeout=5s
+`,
+		Args: cobra.ArbitraryArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return silenceExit(cmd, validationExitError(verify(cmd, v, args)))
+		},
+	}
+
+	verifyCmd.Flags().String("socket", "", "Listen on this unix socket instead of reading files")
+	v.BindPFlag("verify.socket", verifyCmd.Flags().Lookup("socket"))
+
+	verifyCmd.Flags().String("idle_timeout", "5s", "Stop listening on the socket when no line arrives for this long")
+	v.BindPFlag("verify.idle_timeout", verifyCmd.Flags().Lookup("idle_timeout"))
+
+	verifyCmd.Flags().String("message_key", "", "Key of the text in structured lines, if it was renamed. By default 'msg', 'message', 'short_message' and 'Body' are tried")
+	v.BindPFlag("verify.message_key", verifyCmd.Flags().Lookup("message_key"))
+
+	verifyCmd.Flags().Int("expect", 0, "Number of lines each stream should have, so lines lost at the end are counted as missing")
+	v.BindPFlag("verify.expect", verifyCmd.Flags().Lookup("expect"))
+
+	return verifyCmd
+}
@@ -0,0 +1,143 @@
+/*
+Copyright © 2023 Ben Orgil
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+func (ts *ExecTestSuite) TestSequenceFormats() {
+	for _, format := range []string{"structured", "raw", "human_readable", "logfmt", "ecs", "gelf", "otel"} {
+		cmd, err := ts.ExecuteCmd([]string{"--stdout=o __I__ \\t", "--stderr={\"json\": \"text\"}", "--repeat=3", "--repeat_interval=0",
+			"--sequence", "--run_id=r1", "--decode_escapes", "--field=k=v", "--output_format=" + format})
+		ts.Require().NoError(err)
+
+		v := newVerifier("")
+		ts.Require().NoError(v.read(strings.NewReader(cmd.RawStdErr + cmd.RawStdOut)))
+		var b bytes.Buffer
+		ts.True(v.report(&b, 3), format+"\n"+b.String())
+		ts.Contains(b.String(), "run_id=r1 stream=stdout lines=3 last_seq=3 missing=0", format)
+		ts.Contains(b.String(), "run_id=r1 stream=stderr lines=3 last_seq=3 missing=0", format)
+	}
+
+	cmd, err := ts.ExecuteCmd([]string{"--stdout=o", "--repeat=2", "--repeat_interval=0", "--sequence", "--run_id=r2", "--output_format=raw"})
+	ts.Require().NoError(err)
+	ts.Regexp(`^o run_id=r2 stream=stdout seq=1 checksum=[0-9a-f]{8}\no run_id=r2 stream=stdout seq=2 checksum=[0-9a-f]{8}\n$`, cmd.RawStdOut)
+
+	// Only the streams that ask for it
+	cmd, err = ts.ExecuteCmd([]string{"--stdout=o", "--stderr=e", "--stderr_sequence", "--output_format=raw"})
+	ts.Require().NoError(err)
+	ts.Equal("o\n", cmd.RawStdOut)
+	ts.Contains(cmd.RawStdErr, "seq=1")
+}
+
+func (ts *ExecTestSuite) TestVerifyProblems() {
+	s := &sequencer{enabled: true, runID: "r", stream: "stdout", suffix: true}
+	var lines []string
+	for i := 0; i < 10; i++ {
+		l, _ := s.apply("line", nil)
+		lines = append(lines, l)
+	}
+
+	// Lose 3 and 4, duplicate 6, swap 8 and 9, corrupt 10 and add a line without a sequence
+	input := []string{lines[0], lines[1], lines[4], lines[5], lines[5], lines[6], lines[8], lines[7],
+		strings.Replace(lines[9], "line", "lime", 1), "unrelated"}
+	v := newVerifier("")
+	ts.Require().NoError(v.read(strings.NewReader(strings.Join(input, "\n"))))
+	var b bytes.Buffer
+	ts.False(v.report(&b, 12))
+	ts.Contains(b.String(), "run_id=r stream=stdout lines=9 last_seq=10 missing=4 duplicates=1 reordered=1 corrupt=1\n")
+	ts.Contains(b.String(), "  missing seq: 3-4, 11-12\n")
+	ts.Contains(b.String(), "lines without a sequence: 1\n")
+	ts.Contains(b.String(), "FAILED\n")
+
+	// Nothing numbered at all
+	b.Reset()
+	ts.False(newVerifier("").report(&b, 0))
+
+	ts.Equal("1, 3-5, 7, ...", missingRanges([]int{1, 3, 4, 5, 7, 9}, 3))
+}
+
+func (ts *ExecTestSuite) TestVerifyMessageKey() {
+	cmd, err := ts.ExecuteCmd([]string{"--stdout=o", "--repeat=2", "--repeat_interval=0", "--sequence", "--rename_field=msg=log"})
+	ts.Require().NoError(err)
+
+	v := newVerifier("")
+	ts.Require().NoError(v.read(strings.NewReader(cmd.RawStdOut)))
+	ts.False(v.report(&bytes.Buffer{}, 0))
+
+	v = newVerifier("log")
+	ts.Require().NoError(v.read(strings.NewReader(cmd.RawStdOut)))
+	ts.True(v.report(&bytes.Buffer{}, 0))
+}
+
+func (ts *ExecTestSuite) TestVerifyCmd() {
+	cmd, err := ts.ExecuteCmd([]string{"--stdout=o", "--repeat=3", "--repeat_interval=0", "--sequence", "--run_id=file"})
+	ts.Require().NoError(err)
+	file := filepath.Join(ts.T().TempDir(), "delivered.log")
+	ts.Require().NoError(os.WriteFile(file, []byte(cmd.RawStdOut), 0644))
+
+	cmd, err = ts.ExecuteCmd([]string{"verify", "--expect=3", file})
+	ts.Require().NoError(err)
+	ts.Equal("run_id=file stream=stdout lines=3 last_seq=3 missing=0 duplicates=0 reordered=0 corrupt=0\nOK\n", cmd.RawStdOut)
+
+	for _, args := range [][]string{
+		{"verify", "--socket=/tmp/et_verify.sock", file},
+		{"verify", "--idle_timeout=0", file},
+		{"verify", "--expect=-1", file},
+	} {
+		_, err = ts.ExecuteCmd(args)
+		ts.ErrorAs(err, new(*paramSetValidationError), args)
+	}
+}
+
+func (ts *ExecTestSuite) TestVerifySocket() {
+	socket := filepath.Join(ts.T().TempDir(), "verify.sock")
+	s := &sequencer{enabled: true, runID: "r", stream: "socket", suffix: true}
+
+	go func() {
+		// Wait for the listener, then send one line per connection like --socket does
+		for i := 0; i < 5; i++ {
+			var conn net.Conn
+			var err error
+			for start := time.Now(); time.Since(start) < time.Second; time.Sleep(10 * time.Millisecond) {
+				if conn, err = net.Dial("unix", socket); err == nil {
+					break
+				}
+			}
+			if err != nil {
+				return
+			}
+			l, _ := s.apply("line", nil)
+			conn.Write([]byte(l + "\n"))
+			conn.Close()
+		}
+	}()
+
+	v := newVerifier("")
+	start := time.Now()
+	ts.Require().NoError(v.listen(context.Background(), socket, 300*time.Millisecond))
+	ts.Less(time.Since(start), 2*time.Second)
+	var b bytes.Buffer
+	ts.True(v.report(&b, 5), b.String())
+}
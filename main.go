@@ -18,6 +18,7 @@ package main
 
 import (
 	"log/slog"
+	"os"
 
 	"github.com/benorgil/exectester/cmd"
 	"github.com/benorgil/exectester/configs"
@@ -26,6 +27,8 @@ import (
 func main() {
 	// Set default logger to the fallback before loading config
 	slog.SetDefault(configs.FallbackLogger)
-	cmd := cmd.RootCmd(configs.FallbackLogger)
-	cmd.Execute()
+	rootCmd := cmd.RootCmd(configs.FallbackLogger)
+	if err := cmd.Execute(rootCmd); err != nil {
+		os.Exit(cmd.ExitCode(err))
+	}
 }